@@ -16,12 +16,10 @@ package cmd
 
 import (
 	"errors"
-	"os"
 
 	"github.com/docker/go-plugins-helpers/volume"
 	"github.com/sirupsen/logrus"
 	"github.com/soopsio/sshfs-go/docker"
-	"github.com/soopsio/sshfs-go/fs"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -42,11 +40,27 @@ var dockerCmd = &cobra.Command{
 		return nil
 	},
 	Run: func(cmd *cobra.Command, args []string) {
+		host, err := resolveHost(viper.GetString("address"))
+		if err != nil {
+			logrus.WithError(err).Fatal("could not read ssh config")
+		}
+
+		config, err := newSSHConfig(host, true, false)
+		if err != nil {
+			logrus.WithError(err).Fatal("invalid ssh configuration")
+		}
+
+		opts, err := fsOptions(host, false)
+		if err != nil {
+			logrus.WithError(err).Fatal("invalid options")
+		}
+
 		driver, err := docker.New(docker.Config{
 			Root:       viper.GetString("root"),
 			MountPoint: args[0],
-			SSHServer:  viper.GetString("address"),
-			SSHConfig:  fs.NewConfig(viper.GetString("username"), viper.GetString("password"), viper.GetString("private-key")),
+			SSHServer:  host.Addr(),
+			SSHConfig:  config,
+			Options:    opts,
 		})
 		if err != nil {
 			logrus.WithError(err).Fatal("driver init failed")
@@ -54,8 +68,8 @@ var dockerCmd = &cobra.Command{
 
 		logrus.WithFields(logrus.Fields{
 			"root":     args[0],
-			"address":  viper.GetString("address"),
-			"username": viper.GetString("username"),
+			"address":  host.Addr(),
+			"username": host.User,
 			"socket":   viper.GetString("socket"),
 		}).Info("starting plugin server")
 
@@ -78,9 +92,8 @@ func init() {
 	RootCmd.AddCommand(dockerCmd)
 
 	dockerCmd.Flags().StringP("address", "a", "127.0.0.1:22", "ssh server address")
-	dockerCmd.Flags().StringP("username", "u", "root", "ssh username")
-	dockerCmd.Flags().StringP("password", "p", "", "ssh password")
 	dockerCmd.Flags().StringP("root", "r", "/tmp", "remote root")
-	dockerCmd.Flags().StringP("private-key", "i", os.Getenv("HOME")+`/.ssh/id_rsa`, "path to private ssh key")
+	addSSHFlags(dockerCmd.Flags())
+	addFSFlags(dockerCmd.Flags())
 	dockerCmd.Flags().StringP("socket", "s", "/run/docker/plugins/ssh.sock", "socket address to communicate with docker")
 }
@@ -27,11 +27,11 @@ import (
 
 // mountCmd represents the mount command
 var mountCmd = &cobra.Command{
-	Use:   "mount {mountpoint}",
+	Use:   "mount [[user@]host:path] {mountpoint}",
 	Short: "mount a SSHFS at the specified mountpoint",
 	PreRunE: func(cmd *cobra.Command, args []string) error {
-		if len(args) == 0 {
-			return errors.New("expected exactly one argument")
+		if len(args) == 0 || len(args) > 2 {
+			return errors.New("expected a mountpoint, optionally preceded by [user@]host:path")
 		}
 
 		if err := viper.BindPFlags(cmd.Flags()); err != nil {
@@ -41,10 +41,28 @@ var mountCmd = &cobra.Command{
 		return nil
 	},
 	Run: func(cmd *cobra.Command, args []string) {
-		config := fs.NewConfig(viper.GetString("username"), viper.GetString("password"), viper.GetString("private-key"))
-		logrus.WithField("address", viper.GetString("address")).Info("creating FUSE client for SSH Server")
+		address, root, mountpoint := viper.GetString("address"), viper.GetString("root"), args[0]
+		if len(args) == 2 {
+			address, root = splitRemote(args[0])
+			mountpoint = args[1]
+		}
+
+		host, err := resolveHost(address)
+		if err != nil {
+			logrus.WithError(err).Fatal("could not read ssh config")
+		}
+
+		config, err := newSSHConfig(host, true, true)
+		if err != nil {
+			logrus.WithError(err).Fatal("invalid ssh configuration")
+		}
+		opts, err := fsOptions(host, true)
+		if err != nil {
+			logrus.WithError(err).Fatal("invalid options")
+		}
+		logrus.WithField("address", host.Addr()).Info("creating FUSE client for SSH Server")
 
-		fs, err := fs.New(config, args[0], viper.GetString("address"), viper.GetString("root"))
+		fs, err := fs.New(config, mountpoint, host.Addr(), root, opts)
 		if err != nil {
 			logrus.WithError(err).Fatal("error creatinging fs")
 		}
@@ -73,8 +91,7 @@ func init() {
 	RootCmd.AddCommand(mountCmd)
 
 	mountCmd.Flags().StringP("address", "a", "127.0.0.1:22", "ssh server address")
-	mountCmd.Flags().StringP("username", "u", "root", "ssh username")
-	mountCmd.Flags().StringP("password", "p", "", "ssh password")
 	mountCmd.Flags().StringP("root", "r", "/opt", "ssh root")
-	mountCmd.Flags().StringP("private-key", "i", os.Getenv("HOME")+`/.ssh/id_rsa`, "path to private ssh key")
+	addSSHFlags(mountCmd.Flags())
+	addFSFlags(mountCmd.Flags())
 }
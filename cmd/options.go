@@ -0,0 +1,107 @@
+// Copyright © 2016 Asteris, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/soopsio/sshfs-go/fs"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// addFSFlags registers the filesystem flags shared by mount and docker
+func addFSFlags(flags *pflag.FlagSet) {
+	flags.Bool("reconnect", true, "reconnect automatically when the ssh connection drops")
+	flags.Duration("reconnect-timeout", 2*time.Minute, "how long filesystem calls wait for the connection to come back")
+	flags.Duration("keepalive-interval", 15*time.Second, "interval between keepalive requests to the ssh server, 0 to disable (overrides ServerAliveInterval)")
+	flags.Int("keepalive-count-max", 3, "unanswered keepalives before the connection is considered dead (overrides ServerAliveCountMax)")
+	flags.Duration("op-timeout", 0, "deadline for a single sftp operation, after which it fails with ETIMEDOUT (0 for none)")
+	flags.String("statfs-fallback", fs.StatfsFallbackFixed, "statfs result when the server lacks statvfs@openssh.com (fixed or error)")
+	flags.Int("max-requests", 64, "concurrent sftp requests per file, raise on high-latency links")
+	flags.String("readahead", "1MB", "data prefetched ahead of sequential reads on each open file, 0 to disable")
+	flags.String("readahead-memory", "64MB", "memory cap for prefetched data across all open files")
+	flags.Duration("attr-timeout", 20*time.Second, "how long file attributes are cached locally and by the kernel, 0 to disable")
+	flags.Duration("entry-timeout", 20*time.Second, "how long directory listings and name lookups are cached locally and by the kernel, 0 to disable")
+	flags.Duration("negative-timeout", 10*time.Second, "how long lookups of missing names are answered with ENOENT without asking the server, 0 to disable")
+	flags.String("cache-dir", "", "directory for a persistent cache of file blocks, empty to disable")
+	flags.String("cache-size", "1GB", "size limit of the block cache, least recently used blocks are evicted first")
+	flags.String("write-buffer", "1MB", "sequential writes buffered per open file before being sent to the server, 0 to write through")
+	flags.Bool("writeback-cache", true, "cache writes in the kernel and send them in large chunks; disable so O_APPEND writes are appended at the server's end of file")
+	flags.String("xattr", fs.XattrNone, "extended attribute storage (none, or sidecar to keep them in hidden .xattr files on the server)")
+	flags.String("fsync", fs.FsyncBestEffort, "fsync handling: strict fails without fsync@openssh.com, best-effort ignores its absence, disabled never syncs")
+}
+
+// fsOptions builds the filesystem options for host from the bound flags
+func fsOptions(host *fs.HostConfig, interactive bool) (fs.Options, error) {
+	jumps, err := resolveJumps(host, interactive)
+	if err != nil {
+		return fs.Options{}, err
+	}
+
+	opts := fs.Options{
+		Jumps:             jumps,
+		Reconnect:         viper.GetBool("reconnect"),
+		ReconnectTimeout:  viper.GetDuration("reconnect-timeout"),
+		KeepaliveInterval: viper.GetDuration("keepalive-interval"),
+		KeepaliveCountMax: viper.GetInt("keepalive-count-max"),
+		OpTimeout:         viper.GetDuration("op-timeout"),
+		StatfsFallback:    viper.GetString("statfs-fallback"),
+		Fsync:             viper.GetString("fsync"),
+		Xattr:             viper.GetString("xattr"),
+		MaxRequests:       viper.GetInt("max-requests"),
+		ReadAhead:         int64(viper.GetSizeInBytes("readahead")),
+		ReadAheadMemory:   int64(viper.GetSizeInBytes("readahead-memory")),
+		AttrTimeout:       viper.GetDuration("attr-timeout"),
+		EntryTimeout:      viper.GetDuration("entry-timeout"),
+		NegativeTimeout:   viper.GetDuration("negative-timeout"),
+		CacheDir:          viper.GetString("cache-dir"),
+		CacheSize:         int64(viper.GetSizeInBytes("cache-size")),
+		WriteBuffer:       int(viper.GetSizeInBytes("write-buffer")),
+		WritebackCache:    viper.GetBool("writeback-cache"),
+	}
+
+	switch opts.StatfsFallback {
+	case fs.StatfsFallbackFixed, fs.StatfsFallbackError:
+	default:
+		return fs.Options{}, fmt.Errorf("unknown statfs fallback %q (one of %s or %s)", opts.StatfsFallback, fs.StatfsFallbackFixed, fs.StatfsFallbackError)
+	}
+
+	switch opts.Fsync {
+	case fs.FsyncStrict, fs.FsyncBestEffort, fs.FsyncDisabled:
+	default:
+		return fs.Options{}, fmt.Errorf("unknown fsync mode %q (one of %s, %s or %s)", opts.Fsync, fs.FsyncStrict, fs.FsyncBestEffort, fs.FsyncDisabled)
+	}
+
+	if opts.MaxRequests < 1 {
+		return fs.Options{}, fmt.Errorf("max-requests must be at least 1")
+	}
+
+	switch opts.Xattr {
+	case fs.XattrNone, fs.XattrSidecar:
+	default:
+		return fs.Options{}, fmt.Errorf("unknown xattr storage %q (one of %s or %s)", opts.Xattr, fs.XattrNone, fs.XattrSidecar)
+	}
+
+	// flags given on the command line take precedence over the ssh config
+	if !viper.IsSet("keepalive-interval") && host.ServerAliveInterval > 0 {
+		opts.KeepaliveInterval = host.ServerAliveInterval
+	}
+	if !viper.IsSet("keepalive-count-max") && host.ServerAliveCountMax > 0 {
+		opts.KeepaliveCountMax = host.ServerAliveCountMax
+	}
+	return opts, nil
+}
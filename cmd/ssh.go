@@ -0,0 +1,284 @@
+// Copyright © 2016 Asteris, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/soopsio/sshfs-go/fs"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// defaultPrivateKey is the key tried when neither --private-key nor the ssh
+// config names one
+var defaultPrivateKey = os.Getenv("HOME") + `/.ssh/id_rsa`
+
+// addSSHFlags registers the ssh client flags shared by mount and docker
+func addSSHFlags(flags *pflag.FlagSet) {
+	flags.StringSlice("jump", nil, "jump hosts to connect through, as user@host:port[,user@host:port...]")
+	flags.String("ssh-config", os.Getenv("HOME")+`/.ssh/config`, "OpenSSH client config used to resolve host aliases")
+	flags.StringP("username", "u", "", "ssh username (default is the ssh config User, then the local user)")
+	flags.StringP("password", "p", "", "ssh password")
+	flags.StringP("private-key", "i", defaultPrivateKey, "path to private ssh key")
+	flags.StringSlice("certificate", nil, "ssh certificate to present with the private key (default is the key path with -cert.pub)")
+	flags.String("passphrase", "", "passphrase for an encrypted private key (or set PASSPHRASE)")
+	flags.Int("passphrase-fd", -1, "read the private key passphrase from this file descriptor")
+	flags.StringSlice("auth-order", fs.DefaultAuthOrder, "order in which auth methods are tried (agent, key, keyboard-interactive, password)")
+	flags.StringSlice("ki-answer", nil, "keyboard-interactive answers, as prompt=answer or a bare answer for any prompt")
+	flags.String("totp-secret-file", "", "file with the base32 TOTP secret used to answer verification code prompts")
+	flags.String("known-hosts", os.Getenv("HOME")+`/.ssh/known_hosts`, "path to known_hosts file used to verify the server")
+	flags.String("host-key-policy", fs.HostKeyStrict, "host key verification (one of strict, accept-new or off)")
+	flags.StringSlice("host-ca", nil, "files with CA public keys trusted to sign host certificates for any host")
+}
+
+// resolveHost resolves the [user@]host[:port] address of the target host
+// through the OpenSSH client config. Explicit flags take precedence over the
+// config file.
+func resolveHost(address string) (*fs.HostConfig, error) {
+	h, user, err := lookupHost(address)
+	if err != nil {
+		return nil, err
+	}
+	if user == "" && viper.IsSet("username") {
+		h.User = viper.GetString("username")
+	}
+	if viper.IsSet("private-key") || len(h.IdentityFiles) == 0 {
+		h.IdentityFiles = append([]string{viper.GetString("private-key")}, h.IdentityFiles...)
+	}
+	return h, nil
+}
+
+// resolveJumpHost resolves a jump host address. The user and identity flags
+// belong to the target host, so a hop only takes them from its address and
+// the ssh config.
+func resolveJumpHost(address string) (*fs.HostConfig, error) {
+	h, _, err := lookupHost(address)
+	if err != nil {
+		return nil, err
+	}
+	if len(h.IdentityFiles) == 0 {
+		h.IdentityFiles = []string{defaultPrivateKey}
+	}
+	return h, nil
+}
+
+// lookupHost resolves a [user@]host[:port] address through the OpenSSH
+// client config, returning the user given in the address, if any
+func lookupHost(address string) (*fs.HostConfig, string, error) {
+	config, err := fs.LoadSSHConfig(viper.GetString("ssh-config"), "/etc/ssh/ssh_config")
+	if err != nil {
+		return nil, "", err
+	}
+
+	user := ""
+	if i := strings.LastIndex(address, "@"); i >= 0 {
+		user, address = address[:i], address[i+1:]
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		host, port = strings.Trim(address, "[]"), ""
+	}
+
+	h := config.Resolve(host)
+	if port != "" {
+		h.Port = port
+	}
+	if user != "" {
+		h.User = user
+	}
+	return h, user, nil
+}
+
+// resolveJumps builds the jump hosts given by --jump, falling back to the
+// ProxyJump of host. Each hop is resolved and authenticated on its own, without
+// the user, key, certificate and password flags meant for the target.
+func resolveJumps(host *fs.HostConfig, interactive bool) ([]fs.Hop, error) {
+	jumps := viper.GetStringSlice("jump")
+	if len(jumps) == 0 && host.ProxyJump != "" {
+		jumps = strings.Split(host.ProxyJump, ",")
+	}
+
+	hops := []fs.Hop{}
+	for _, jump := range jumps {
+		hop, err := resolveJumpHost(strings.TrimPrefix(strings.TrimSpace(jump), "ssh://"))
+		if err != nil {
+			return nil, err
+		}
+		config, err := newSSHConfig(hop, false, interactive)
+		if err != nil {
+			return nil, fmt.Errorf("jump host %s: %v", jump, err)
+		}
+		logrus.WithField("address", hop.Addr()).Debug("using jump host")
+		hops = append(hops, fs.Hop{Addr: hop.Addr(), Config: config})
+	}
+	return hops, nil
+}
+
+// splitRemote splits a [user@]host:path argument into address and path
+func splitRemote(remote string) (string, string) {
+	start := strings.Index(remote, "@") + 1
+	if strings.HasPrefix(remote[start:], "[") {
+		if end := strings.Index(remote[start:], "]"); end >= 0 {
+			start += end
+		}
+	}
+
+	i := strings.Index(remote[start:], ":")
+	if i < 0 {
+		return remote, ""
+	}
+	return remote[:start+i], remote[start+i+1:]
+}
+
+// newSSHConfig builds the ssh client config for host from the bound flags.
+// The password and certificate flags only apply when host is the target.
+// When interactive is set, missing secrets may be prompted for on the terminal.
+func newSSHConfig(host *fs.HostConfig, target, interactive bool) (*ssh.ClientConfig, error) {
+	password, certificates := "", []string(nil)
+	if target {
+		password, certificates = viper.GetString("password"), viper.GetStringSlice("certificate")
+	}
+	responder := &fs.Responder{
+		Answers:  viper.GetStringSlice("ki-answer"),
+		Password: password,
+	}
+	if file := viper.GetString("totp-secret-file"); file != "" {
+		secret, err := fs.LoadTOTPSecret(file)
+		if err != nil {
+			return nil, err
+		}
+		responder.TOTPSecret = secret
+	}
+	if interactive {
+		responder.Prompt = prompt
+	}
+
+	return fs.NewConfig(fs.ClientOptions{
+		User:           host.User,
+		Password:       password,
+		PrivateKeys:    host.IdentityFiles,
+		IdentitiesOnly: host.IdentitiesOnly,
+		Certificates:   certificates,
+		Passphrase:     passphrase(interactive),
+		Responder:      responder,
+		AuthOrder:      viper.GetStringSlice("auth-order"),
+		KnownHosts:     []string{viper.GetString("known-hosts")},
+		HostKeyPolicy:  viper.GetString("host-key-policy"),
+		HostCAs:        viper.GetStringSlice("host-ca"),
+	})
+}
+
+// passphrase returns the source for private key passphrases: the passphrase
+// flag, then the passphrase file descriptor, then the terminal
+func passphrase(interactive bool) fs.PassphraseFunc {
+	return func(file string) ([]byte, error) {
+		if pass, ok := passphrases[file]; ok {
+			return pass, nil
+		}
+		pass, err := readPassphrase(file, interactive)
+		if err == nil {
+			passphrases[file] = pass
+		}
+		return pass, err
+	}
+}
+
+// passphrases caches passphrases by key file, so a key shared by several
+// hops is only asked for once
+var passphrases = map[string][]byte{}
+
+// readPassphrase reads the passphrase for file from the first configured source
+func readPassphrase(file string, interactive bool) ([]byte, error) {
+	if pass := viper.GetString("passphrase"); pass != "" {
+		return []byte(pass), nil
+	}
+
+	if fd := viper.GetInt("passphrase-fd"); fd >= 0 {
+		fdPassphraseOnce.Do(func() {
+			fdPassphrase, fdPassphraseErr = readPassphraseFd(fd)
+		})
+		return fdPassphrase, fdPassphraseErr
+	}
+
+	if interactive {
+		return readSecret(fmt.Sprintf("Enter passphrase for key '%s': ", file))
+	}
+	return nil, errors.New("no passphrase given (use --passphrase or --passphrase-fd)")
+}
+
+// The passphrase file descriptor can only be read once, so its value is kept
+// for every key that needs it
+var (
+	fdPassphraseOnce sync.Once
+	fdPassphrase     []byte
+	fdPassphraseErr  error
+)
+
+// readPassphraseFd reads the first line from file descriptor fd and closes it
+func readPassphraseFd(fd int) ([]byte, error) {
+	f := os.NewFile(uintptr(fd), "passphrase")
+	if f == nil {
+		return nil, fmt.Errorf("invalid passphrase file descriptor %d", fd)
+	}
+	defer f.Close()
+	line, err := bufio.NewReader(f).ReadString('\n')
+	if err != nil && line == "" {
+		return nil, err
+	}
+	return []byte(strings.TrimRight(line, "\r\n")), nil
+}
+
+// prompt asks a keyboard-interactive question on the terminal
+func prompt(question string, echo bool) (string, error) {
+	if !echo {
+		answer, err := readSecret(question)
+		return string(answer), err
+	}
+
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return "", fmt.Errorf("no terminal to prompt on: %v", err)
+	}
+	defer tty.Close()
+
+	fmt.Fprint(tty, question)
+	answer, err := bufio.NewReader(tty).ReadString('\n')
+	return strings.TrimRight(answer, "\r\n"), err
+}
+
+// readSecret prompts on the controlling terminal and reads a line without echo
+func readSecret(prompt string) ([]byte, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("no terminal to prompt on: %v", err)
+	}
+	defer tty.Close()
+
+	fmt.Fprint(tty, prompt)
+	secret, err := term.ReadPassword(int(tty.Fd()))
+	fmt.Fprintln(tty)
+	return secret, err
+}
@@ -15,6 +15,7 @@
 package docker
 
 import (
+	"github.com/soopsio/sshfs-go/fs"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -26,4 +27,6 @@ type Config struct {
 	// Address and config for ssh
 	SSHServer string
 	SSHConfig *ssh.ClientConfig
+	// Options for every mounted volume
+	Options fs.Options
 }
@@ -46,7 +46,7 @@ type Driver struct {
 
 // New instantiates a new driver and returns it
 func New(config Config) (*Driver, error) {
-	client, err := fs.NewSftp(config.SSHConfig, config.SSHServer)
+	client, err := fs.NewSftp(config.SSHConfig, config.SSHServer, config.Options.Jumps...)
 	if err != nil {
 		return nil, err
 	}
@@ -179,7 +179,7 @@ func (d *Driver) Mount(r *volume.MountRequest) (*volume.MountResponse, error) {
 		return &volume.MountResponse{}, fmt.Errorf("%s already exists and is not a directory", mount)
 	}
 
-	server, err = NewServer(d.config.SSHConfig, mount, d.config.SSHServer, filepath.Join(d.config.Root, r.Name))
+	server, err = NewServer(d.config.SSHConfig, mount, d.config.SSHServer, filepath.Join(d.config.Root, r.Name), d.config.Options)
 	if err != nil {
 		logger.WithError(err).Error("error creating server")
 		return &volume.MountResponse{}, err
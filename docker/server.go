@@ -29,8 +29,8 @@ type Server struct {
 }
 
 // NewServer returns a new server with initial state
-func NewServer(config *ssh.ClientConfig, mountpoint, server, root string) (*Server, error) {
-	fs, err := fs.New(config, mountpoint, server, root)
+func NewServer(config *ssh.ClientConfig, mountpoint, server, root string, opts fs.Options) (*Server, error) {
+	fs, err := fs.New(config, mountpoint, server, root, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,52 @@
+package fs
+
+import (
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// agentKeyring 通过 SSH_AUTH_SOCK 与 ssh-agent 通信
+type agentKeyring struct {
+	socket string
+	conn   net.Conn
+	client agent.ExtendedAgent
+	sync.Mutex
+}
+
+// newAgentKeyring 创建 ssh-agent 客户端，首次使用时才连接
+func newAgentKeyring(socket string) *agentKeyring {
+	return &agentKeyring{socket: socket}
+}
+
+// Signers 返回 agent 提供的全部身份，连接失效时重新连接一次
+func (a *agentKeyring) Signers() ([]ssh.Signer, error) {
+	a.Lock()
+	defer a.Unlock()
+
+	if a.socket == "" {
+		return nil, errors.New("SSH_AUTH_SOCK is not set")
+	}
+
+	if a.client != nil {
+		signers, err := a.client.Signers()
+		if err == nil {
+			return signers, nil
+		}
+		logrus.WithError(err).Debug("ssh-agent connection lost, reconnecting")
+		a.conn.Close()
+		a.client = nil
+	}
+
+	conn, err := net.Dial("unix", a.socket)
+	if err != nil {
+		return nil, err
+	}
+	a.conn = conn
+	a.client = agent.NewClient(conn)
+	return a.client.Signers()
+}
@@ -0,0 +1,40 @@
+package fs
+
+import (
+	"context"
+	"os"
+	"strconv"
+)
+
+// stat 获取节点的远程文件信息，优先使用属性缓存。
+// 目录跟随符号链接（挂载的根目录可能是链接），文件和符号链接不跟随
+func (n *Node) stat(ctx context.Context) (os.FileInfo, error) {
+	if info, ok := n.sftp.attrs.Get(strconv.FormatUint(n.inode, 10)); ok {
+		return info.(os.FileInfo), nil
+	}
+
+	var info os.FileInfo
+	var err error
+	if n.isdir {
+		info, err = n.sftp.Stat(ctx, n.Path())
+	} else {
+		info, err = n.sftp.Lstat(ctx, n.Path())
+	}
+	if err != nil {
+		return nil, err
+	}
+	n.cacheAttr(info)
+	return info, nil
+}
+
+// cacheAttr 缓存节点的远程文件信息，AttrTimeout 为 0 时不缓存
+func (n *Node) cacheAttr(info os.FileInfo) {
+	if ttl := n.sftp.opts.AttrTimeout; ttl > 0 {
+		n.sftp.attrs.Set(strconv.FormatUint(n.inode, 10), info, ttl)
+	}
+}
+
+// invalidateAttr 丢弃节点的缓存属性，在通过挂载点修改节点后调用
+func (n *Node) invalidateAttr() {
+	n.sftp.attrs.Delete(strconv.FormatUint(n.inode, 10))
+}
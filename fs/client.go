@@ -0,0 +1,786 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	kv "github.com/patrickmn/go-cache"
+	"github.com/pkg/sftp"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+// 重连的退避时间
+const (
+	reconnectMinDelay = 500 * time.Millisecond
+	reconnectMaxDelay = 30 * time.Second
+)
+
+var (
+	errClientClosed   = errors.New("sftp client closed")
+	errConnectionLost = errors.New("sftp connection lost")
+)
+
+// Client 可自动重连的 sftp 客户端，SSHFS 和所有节点共享同一个 Client。
+// 连接断开后下一次调用会按退避时间重新拨号，幂等操作在重连后自动重试
+type Client struct {
+	dial       func() (*ssh.Client, error)
+	opts       Options
+	ssh        *ssh.Client
+	sftp       *sftp.Client
+	generation uint64 // 连接代数，每次重连加一
+	waiting    chan struct{}
+	err        error
+	closed     bool
+	cache      *diskCache // 文件块的磁盘缓存，未启用时为 nil
+	attrs      *kv.Cache  // 节点属性缓存，按 inode 保存远程文件信息，同一 inode 的硬链接共用一项
+	entries    *kv.Cache  // 目录内容缓存，按目录 inode 保存最近一次列目录的结果
+	negatives  *kv.Cache  // 不存在的名称，按父目录 inode 和名称保存
+	server     *fs.Server // 挂载后的 FUSE 服务，用于通知内核丢弃目录项，未挂载时为 nil
+	sync.Mutex
+}
+
+// NewClient 建立首个连接，重连和保活按 opts 配置
+func NewClient(dial func() (*ssh.Client, error), opts Options) (*Client, error) {
+	c := newClient(dial, opts)
+	if err := c.connect(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// newClient 创建尚未连接的 Client。
+// 元数据缓存按 inode 保存，每个挂载的 inode 独立编号，因此缓存随 Client 创建，不在挂载之间共享
+func newClient(dial func() (*ssh.Client, error), opts Options) *Client {
+	return &Client{
+		dial:      dial,
+		opts:      opts,
+		attrs:     kv.New(kv.NoExpiration, time.Minute),
+		entries:   kv.New(kv.NoExpiration, time.Minute),
+		negatives: kv.New(kv.NoExpiration, time.Minute),
+	}
+}
+
+// connect 拨号并替换当前连接
+func (c *Client) connect() error {
+	conn, err := c.dial()
+	if err != nil {
+		return err
+	}
+	options := []sftp.ClientOption{}
+	if c.opts.MaxRequests > 0 {
+		options = append(options, sftp.MaxConcurrentRequestsPerFile(c.opts.MaxRequests))
+	}
+	// 写缓冲提交的大块写入拆分为并发的请求，错误在 Flush 时统一返回
+	if c.opts.WriteBuffer > 0 {
+		options = append(options, sftp.UseConcurrentWrites(true))
+	}
+	client, err := sftp.NewClient(conn, options...)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	// 重连期间 Client 被关闭时丢弃新连接，否则连接和保活协程会泄漏
+	c.Lock()
+	if c.closed {
+		c.Unlock()
+		conn.Close()
+		client.Close()
+		return errClientClosed
+	}
+	c.ssh, c.sftp = conn, client
+	c.generation++
+	generation := c.generation
+	c.Unlock()
+
+	go func() {
+		err := client.Wait()
+		logrus.WithError(err).Debug("sftp session ended")
+		c.invalidate(generation)
+	}()
+	if c.opts.KeepaliveInterval > 0 {
+		go c.keepalive(conn, generation)
+	}
+	return nil
+}
+
+// keepalive 定期发送 keepalive@openssh.com 请求，连续 KeepaliveCountMax 次未收到回复时
+// 认为连接已失效并关闭，阻塞在该连接上的调用随之失败或重连
+func (c *Client) keepalive(conn *ssh.Client, generation uint64) {
+	ticker := time.NewTicker(c.opts.KeepaliveInterval)
+	defer ticker.Stop()
+
+	replies := make(chan error, 1)
+	pending, missed := false, 0
+	for {
+		select {
+		case err := <-replies:
+			pending = false
+			if err != nil {
+				logrus.WithError(err).Debug("keepalive failed")
+				c.invalidate(generation)
+				return
+			}
+			missed = 0
+			continue
+		case <-ticker.C:
+		}
+
+		if !c.current(generation) {
+			return
+		}
+		if pending {
+			missed++
+			if c.opts.KeepaliveCountMax > 0 && missed >= c.opts.KeepaliveCountMax {
+				logrus.WithFields(logrus.Fields{
+					"missed":   missed,
+					"interval": c.opts.KeepaliveInterval,
+				}).Warn("ssh server not responding to keepalives, dropping connection")
+				c.invalidate(generation)
+				return
+			}
+			continue
+		}
+
+		// 服务器不认识该请求时回复失败，同样说明连接可用
+		pending = true
+		go func() {
+			_, _, err := conn.SendRequest("keepalive@openssh.com", true, nil)
+			replies <- err
+		}()
+	}
+}
+
+// current 判断第 generation 代连接是否仍在使用
+func (c *Client) current(generation uint64) bool {
+	c.Lock()
+	defer c.Unlock()
+	return c.generation == generation && c.sftp != nil
+}
+
+// get 返回当前连接，连接已断开时等待重连，ctx 结束时放弃等待
+func (c *Client) get(ctx context.Context) (*sftp.Client, uint64, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	for {
+		if c.closed {
+			return nil, 0, errClientClosed
+		}
+		if c.sftp != nil {
+			return c.sftp, c.generation, nil
+		}
+		if !c.opts.Reconnect {
+			return nil, 0, errConnectionLost
+		}
+
+		if c.waiting == nil {
+			c.waiting = make(chan struct{})
+			go c.redial(c.waiting)
+		}
+		waiting := c.waiting
+		c.Unlock()
+		select {
+		case <-waiting:
+		case <-ctx.Done():
+			c.Lock()
+			return nil, 0, contextError(ctx.Err())
+		}
+		c.Lock()
+
+		if c.sftp == nil && c.err != nil {
+			return nil, 0, c.err
+		}
+	}
+}
+
+// redial 按退避时间重新拨号，超过 timeout 后放弃，由下一次调用重新开始
+func (c *Client) redial(done chan struct{}) {
+	defer close(done)
+
+	deadline := time.Now().Add(c.opts.ReconnectTimeout)
+	delay := reconnectMinDelay
+	for attempt := 1; ; attempt++ {
+		err := c.connect()
+		if err == nil {
+			logrus.WithField("attempt", attempt).Info("sftp connection re-established")
+			c.Lock()
+			c.waiting, c.err = nil, nil
+			c.Unlock()
+			return
+		}
+
+		c.Lock()
+		if c.closed {
+			c.waiting, c.err = nil, errClientClosed
+			c.Unlock()
+			return
+		}
+		if time.Now().Add(delay).After(deadline) {
+			c.waiting, c.err = nil, errConnectionLost
+			c.Unlock()
+			logrus.WithError(err).WithField("attempt", attempt).Error("giving up reconnecting")
+			return
+		}
+		c.Unlock()
+
+		logrus.WithError(err).WithFields(logrus.Fields{
+			"attempt": attempt,
+			"delay":   delay,
+		}).Warn("reconnect failed")
+		time.Sleep(delay)
+		if delay *= 2; delay > reconnectMaxDelay {
+			delay = reconnectMaxDelay
+		}
+	}
+}
+
+// invalidate 关闭第 generation 代连接，之后的调用将触发重连
+func (c *Client) invalidate(generation uint64) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.generation != generation || c.sftp == nil {
+		return
+	}
+	logrus.WithField("generation", generation).Warn("sftp connection lost")
+	// 先关闭 ssh 连接，sftp.Client.Close 会等待接收循环退出
+	c.ssh.Close()
+	c.sftp.Close()
+	c.sftp, c.ssh = nil, nil
+}
+
+// lost 判断 err 是否因为第 generation 代连接断开
+func (c *Client) lost(err error, generation uint64) bool {
+	if err == nil {
+		return false
+	}
+	if isConnectionError(err) {
+		c.invalidate(generation)
+		return true
+	}
+
+	return !c.current(generation)
+}
+
+// isConnectionError 判断是否为连接断开导致的错误
+func isConnectionError(err error) bool {
+	return errors.Is(err, sftp.ErrSSHFxConnectionLost) ||
+		errors.Is(err, sftp.ErrSSHFxNoConnection) ||
+		errors.Is(err, io.ErrClosedPipe) ||
+		errors.Is(err, net.ErrClosed)
+}
+
+// contextError 将 ctx 的错误转换为 FUSE 错误码：超时返回 ETIMEDOUT，被中断返回 EINTR
+func contextError(err error) error {
+	if err == context.DeadlineExceeded {
+		return fuse.Errno(syscall.ETIMEDOUT)
+	}
+	return fuse.EINTR
+}
+
+// result 异步调用的结果
+type result struct {
+	value interface{}
+	err   error
+}
+
+// call 在新的 goroutine 中执行 fn，ctx 结束时立即返回而不等待服务器响应。
+// 被放弃的调用完成后，实现了 io.Closer 的结果会被关闭，避免泄漏远程句柄
+func call(ctx context.Context, fn func() (interface{}, error)) (interface{}, error) {
+	if ctx.Done() == nil {
+		return fn()
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		value, err := fn()
+		done <- result{value, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.value, r.err
+	case <-ctx.Done():
+		go func() {
+			r := <-done
+			if closer, ok := r.value.(io.Closer); ok && r.err == nil {
+				closer.Close()
+			}
+		}()
+		return nil, contextError(ctx.Err())
+	}
+}
+
+// withTimeout 为单次操作设置 OpTimeout 截止时间
+func (c *Client) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.opts.OpTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.opts.OpTimeout)
+}
+
+// wait 执行 fn 并等待其完成，不受 ctx 影响。修改远程状态的请求一旦发出就不能放弃：
+// 放弃后请求仍会在服务器上生效，写入的数据也仍在从调用者的缓冲区读取
+func wait(ctx context.Context, fn func() (interface{}, error)) (interface{}, error) {
+	return fn()
+}
+
+// do 在当前连接上执行只读操作 fn，连接断开且 retry 为 true 时在新连接上重试一次。
+// ctx 被取消或超过 OpTimeout 时返回 EINTR 或 ETIMEDOUT
+func (c *Client) do(ctx context.Context, retry bool, fn func(client *sftp.Client) (interface{}, error)) (interface{}, error) {
+	return c.exec(ctx, retry, call, fn)
+}
+
+// modify 在当前连接上执行修改远程状态的操作 fn，连接断开且 retry 为 true 时在新连接上重试一次。
+// ctx 只限制等待重连的时间，请求发出后总是等待服务器响应
+func (c *Client) modify(ctx context.Context, retry bool, fn func(client *sftp.Client) (interface{}, error)) (interface{}, error) {
+	return c.exec(ctx, retry, wait, fn)
+}
+
+// exec 通过 run 在当前连接上执行 fn，连接断开且 retry 为 true 时在新连接上重试一次
+func (c *Client) exec(ctx context.Context, retry bool, run func(context.Context, func() (interface{}, error)) (interface{}, error), fn func(client *sftp.Client) (interface{}, error)) (interface{}, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	client, generation, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := run(ctx, func() (interface{}, error) {
+		return fn(client)
+	})
+	if !retry || !c.lost(err, generation) {
+		return value, err
+	}
+
+	client, _, err = c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return run(ctx, func() (interface{}, error) {
+		return fn(client)
+	})
+}
+
+// Close 关闭连接，之后不再重连
+func (c *Client) Close() error {
+	c.Lock()
+	defer c.Unlock()
+
+	c.closed = true
+	if c.sftp == nil {
+		return nil
+	}
+	err := c.ssh.Close()
+	c.sftp.Close()
+	c.sftp, c.ssh = nil, nil
+	return err
+}
+
+// Stat sftp.Client.Stat
+func (c *Client) Stat(ctx context.Context, p string) (os.FileInfo, error) {
+	stat, err := c.do(ctx, true, func(client *sftp.Client) (interface{}, error) {
+		return client.Stat(p)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stat.(os.FileInfo), nil
+}
+
+// Lstat sftp.Client.Lstat
+func (c *Client) Lstat(ctx context.Context, p string) (os.FileInfo, error) {
+	stat, err := c.do(ctx, true, func(client *sftp.Client) (interface{}, error) {
+		return client.Lstat(p)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stat.(os.FileInfo), nil
+}
+
+// ReadLink sftp.Client.ReadLink
+func (c *Client) ReadLink(ctx context.Context, p string) (string, error) {
+	target, err := c.do(ctx, true, func(client *sftp.Client) (interface{}, error) {
+		return client.ReadLink(p)
+	})
+	if err != nil {
+		return "", err
+	}
+	return target.(string), nil
+}
+
+// Symlink sftp.Client.Symlink，创建指向 target 的符号链接 p
+func (c *Client) Symlink(ctx context.Context, target, p string) error {
+	_, err := c.modify(ctx, false, func(client *sftp.Client) (interface{}, error) {
+		return nil, client.Symlink(target, p)
+	})
+	return err
+}
+
+// Link 通过 hardlink@openssh.com 扩展创建硬链接，服务器不支持时返回 ENOTSUP
+func (c *Client) Link(ctx context.Context, oldname, newname string) error {
+	_, err := c.modify(ctx, false, func(client *sftp.Client) (interface{}, error) {
+		if _, ok := client.HasExtension("hardlink@openssh.com"); !ok {
+			return nil, fuse.ENOTSUP
+		}
+		return nil, client.Link(oldname, newname)
+	})
+	return err
+}
+
+// StatVFS 通过 statvfs@openssh.com 扩展获取文件系统统计信息，服务器不支持时返回 ENOTSUP
+func (c *Client) StatVFS(ctx context.Context, p string) (*sftp.StatVFS, error) {
+	stat, err := c.do(ctx, true, func(client *sftp.Client) (interface{}, error) {
+		if _, ok := client.HasExtension("statvfs@openssh.com"); !ok {
+			return nil, fuse.ENOTSUP
+		}
+		return client.StatVFS(p)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stat.(*sftp.StatVFS), nil
+}
+
+// ReadDir sftp.Client.ReadDirContext
+func (c *Client) ReadDir(ctx context.Context, p string) ([]os.FileInfo, error) {
+	entries, err := c.do(ctx, true, func(client *sftp.Client) (interface{}, error) {
+		return client.ReadDirContext(ctx, p)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries.([]os.FileInfo), nil
+}
+
+// Getwd sftp.Client.Getwd
+func (c *Client) Getwd(ctx context.Context) (string, error) {
+	wd, err := c.do(ctx, true, func(client *sftp.Client) (interface{}, error) {
+		return client.Getwd()
+	})
+	if err != nil {
+		return "", err
+	}
+	return wd.(string), nil
+}
+
+// Chmod sftp.Client.Chmod
+func (c *Client) Chmod(ctx context.Context, p string, mode os.FileMode) error {
+	_, err := c.modify(ctx, true, func(client *sftp.Client) (interface{}, error) {
+		return nil, client.Chmod(p, mode)
+	})
+	return err
+}
+
+// Chown sftp.Client.Chown
+func (c *Client) Chown(ctx context.Context, p string, uid, gid int) error {
+	_, err := c.modify(ctx, true, func(client *sftp.Client) (interface{}, error) {
+		return nil, client.Chown(p, uid, gid)
+	})
+	return err
+}
+
+// Chtimes sftp.Client.Chtimes
+func (c *Client) Chtimes(ctx context.Context, p string, atime, mtime time.Time) error {
+	_, err := c.modify(ctx, true, func(client *sftp.Client) (interface{}, error) {
+		return nil, client.Chtimes(p, atime, mtime)
+	})
+	return err
+}
+
+// Truncate sftp.Client.Truncate
+func (c *Client) Truncate(ctx context.Context, p string, size int64) error {
+	_, err := c.modify(ctx, true, func(client *sftp.Client) (interface{}, error) {
+		return nil, client.Truncate(p, size)
+	})
+	return err
+}
+
+// Mkdir sftp.Client.Mkdir
+func (c *Client) Mkdir(ctx context.Context, p string) error {
+	_, err := c.modify(ctx, false, func(client *sftp.Client) (interface{}, error) {
+		return nil, client.Mkdir(p)
+	})
+	return err
+}
+
+// Remove sftp.Client.Remove
+func (c *Client) Remove(ctx context.Context, p string) error {
+	_, err := c.modify(ctx, false, func(client *sftp.Client) (interface{}, error) {
+		return nil, client.Remove(p)
+	})
+	return err
+}
+
+// RemoveDirectory sftp.Client.RemoveDirectory
+func (c *Client) RemoveDirectory(ctx context.Context, p string) error {
+	_, err := c.modify(ctx, false, func(client *sftp.Client) (interface{}, error) {
+		return nil, client.RemoveDirectory(p)
+	})
+	return err
+}
+
+// Rename 重命名并覆盖已存在的目标。服务器支持 posix-rename@openssh.com 时原子地完成，
+// 否则只在因目标文件已存在而失败（SSH_FX_FAILURE，源和目标都存在）时先删除目标再重命名
+func (c *Client) Rename(ctx context.Context, oldname, newname string) error {
+	_, err := c.modify(ctx, false, func(client *sftp.Client) (interface{}, error) {
+		if _, ok := client.HasExtension("posix-rename@openssh.com"); ok {
+			return nil, client.PosixRename(oldname, newname)
+		}
+
+		err := client.Rename(oldname, newname)
+		if err == nil {
+			return nil, nil
+		}
+		statusErr := &sftp.StatusError{}
+		if !errors.As(err, &statusErr) || statusErr.FxCode() != sftp.ErrSSHFxFailure {
+			return nil, err
+		}
+		if _, statErr := client.Lstat(oldname); statErr != nil {
+			return nil, err
+		}
+		stat, statErr := client.Lstat(newname)
+		if statErr != nil || stat.IsDir() {
+			return nil, err
+		}
+		if removeErr := client.Remove(newname); removeErr != nil {
+			return nil, err
+		}
+		return nil, client.Rename(oldname, newname)
+	})
+	return err
+}
+
+// ReadFile 读取整个远程文件
+func (c *Client) ReadFile(ctx context.Context, p string) ([]byte, error) {
+	data, err := c.do(ctx, true, func(client *sftp.Client) (interface{}, error) {
+		file, err := client.Open(p)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		return ioutil.ReadAll(file)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data.([]byte), nil
+}
+
+// WriteFile 以 data 替换远程文件的内容，文件不存在时创建
+func (c *Client) WriteFile(ctx context.Context, p string, data []byte) error {
+	_, err := c.modify(ctx, true, func(client *sftp.Client) (interface{}, error) {
+		file, err := client.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := file.Write(data); err != nil {
+			file.Close()
+			return nil, err
+		}
+		return nil, file.Close()
+	})
+	return err
+}
+
+// Create sftp.Client.Create
+func (c *Client) Create(ctx context.Context, p string) (*Handle, error) {
+	return c.OpenFile(ctx, p, os.O_RDWR|os.O_CREATE|os.O_TRUNC)
+}
+
+// OpenFile 打开远程文件，返回的 Handle 在重连后会重新打开文件
+func (c *Client) OpenFile(ctx context.Context, p string, flags int) (*Handle, error) {
+	// 创建或截断文件的打开同样修改远程文件，不能放弃
+	run := call
+	if flags&(os.O_CREATE|os.O_TRUNC) != 0 {
+		run = wait
+	}
+	h, err := c.exec(ctx, flags&os.O_EXCL == 0, run, func(client *sftp.Client) (interface{}, error) {
+		file, err := client.OpenFile(p, flags)
+		if err != nil {
+			return nil, err
+		}
+
+		// 打开期间连接已重建时 generation 保持为 0，首次使用时重新打开
+		h := &Handle{client: c, path: p, flags: flags, file: file}
+		c.Lock()
+		if c.sftp == client {
+			h.generation = c.generation
+		}
+		c.Unlock()
+		return h, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	handle := h.(*Handle)
+	if c.opts.WriteBuffer > 0 && flags&(os.O_WRONLY|os.O_RDWR) != 0 {
+		handle.buffer = &writeBuffer{size: c.opts.WriteBuffer}
+	}
+	return handle, nil
+}
+
+// Handle 可重连的远程文件句柄，记录路径和打开标志以便在新连接上重新打开
+type Handle struct {
+	client     *Client
+	path       string
+	flags      int
+	file       *sftp.File
+	generation uint64
+	buffer     *writeBuffer // 写缓冲，只读句柄或未启用时为 nil
+	sync.Mutex
+}
+
+// get 返回当前连接上的文件，连接重建后以原有标志（去掉创建和截断）重新打开
+func (h *Handle) get(ctx context.Context) (*sftp.File, uint64, error) {
+	client, generation, err := h.client.get(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	h.Lock()
+	defer h.Unlock()
+	if h.file != nil && h.generation == generation {
+		return h.file, generation, nil
+	}
+
+	file, err := call(ctx, func() (interface{}, error) {
+		return client.OpenFile(h.path, h.flags&^(os.O_CREATE|os.O_EXCL|os.O_TRUNC))
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	logrus.WithFields(logrus.Fields{
+		"path":       h.path,
+		"generation": generation,
+	}).Info("re-opened file after reconnect")
+	h.file, h.generation = file.(*sftp.File), generation
+	return h.file, generation, nil
+}
+
+// do 在当前文件上执行只读操作 fn，连接断开且 retry 为 true 时在重新打开的文件上重试一次
+func (h *Handle) do(ctx context.Context, retry bool, fn func(file *sftp.File) (interface{}, error)) (interface{}, error) {
+	return h.exec(ctx, retry, call, fn)
+}
+
+// modify 在当前文件上执行修改文件的操作 fn，请求发出后总是等待服务器响应
+func (h *Handle) modify(ctx context.Context, retry bool, fn func(file *sftp.File) (interface{}, error)) (interface{}, error) {
+	return h.exec(ctx, retry, wait, fn)
+}
+
+// exec 通过 run 在当前文件上执行 fn，连接断开且 retry 为 true 时在重新打开的文件上重试一次
+func (h *Handle) exec(ctx context.Context, retry bool, run func(context.Context, func() (interface{}, error)) (interface{}, error), fn func(file *sftp.File) (interface{}, error)) (interface{}, error) {
+	ctx, cancel := h.client.withTimeout(ctx)
+	defer cancel()
+
+	file, generation, err := h.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := run(ctx, func() (interface{}, error) {
+		return fn(file)
+	})
+	if !retry || !h.client.lost(err, generation) {
+		return value, err
+	}
+
+	file, _, err = h.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return run(ctx, func() (interface{}, error) {
+		return fn(file)
+	})
+}
+
+// ReadAt 读取 off 处的数据，连接断开时重试
+func (h *Handle) ReadAt(ctx context.Context, b []byte, off int64) (int, error) {
+	n, err := h.do(ctx, true, func(file *sftp.File) (interface{}, error) {
+		return file.ReadAt(b, off)
+	})
+	if n == nil {
+		return 0, err
+	}
+	return n.(int), err
+}
+
+// WriteAt 写入 off 处
+func (h *Handle) WriteAt(ctx context.Context, b []byte, off int64) (int, error) {
+	n, err := h.modify(ctx, false, func(file *sftp.File) (interface{}, error) {
+		return file.WriteAt(b, off)
+	})
+	if n == nil {
+		return 0, err
+	}
+	return n.(int), err
+}
+
+// Append 写入远程文件当前的末尾，不依赖服务器对 SSH_FXF_APPEND 的支持
+func (h *Handle) Append(ctx context.Context, b []byte) (int, error) {
+	n, err := h.modify(ctx, false, func(file *sftp.File) (interface{}, error) {
+		stat, err := file.Stat()
+		if err != nil {
+			return 0, err
+		}
+		return file.WriteAt(b, stat.Size())
+	})
+	if n == nil {
+		return 0, err
+	}
+	return n.(int), err
+}
+
+// Sync 通过 fsync@openssh.com 扩展将文件写入服务器的稳定存储，服务器不支持时返回 ENOTSUP
+func (h *Handle) Sync(ctx context.Context) error {
+	_, err := h.do(ctx, true, func(file *sftp.File) (interface{}, error) {
+		return nil, file.Sync()
+	})
+
+	statusErr := &sftp.StatusError{}
+	if errors.As(err, &statusErr) && statusErr.FxCode() == sftp.ErrSSHFxOpUnsupported {
+		return fuse.ENOTSUP
+	}
+	return err
+}
+
+// Close 提交缓冲的写入后关闭文件，返回延迟写入的错误，连接已断开时只丢弃句柄。
+// 关闭不可中断，但受 OpTimeout 限制
+func (h *Handle) Close() error {
+	ctx, cancel := h.client.withTimeout(context.Background())
+	defer cancel()
+	flushErr := h.Flush(ctx)
+
+	h.Lock()
+	defer h.Unlock()
+	if h.file == nil {
+		return flushErr
+	}
+
+	file := h.file
+	h.file = nil
+	_, err := call(ctx, func() (interface{}, error) {
+		return nil, file.Close()
+	})
+	if flushErr != nil {
+		return flushErr
+	}
+	if isConnectionError(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,98 @@
+package fs
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// newTestClient 返回连接到进程内内存 sftp 服务器的 Client
+func newTestClient(t *testing.T, opts Options) *Client {
+	return serveTestClient(t, opts, sftp.InMemHandler())
+}
+
+// serveTestClient 返回连接到进程内 sftp 服务器的 Client，服务器由 handlers 实现
+func serveTestClient(t *testing.T, opts Options, handlers sftp.Handlers) *Client {
+	t.Helper()
+	server, client := net.Pipe()
+	srv := sftp.NewRequestServer(server, handlers)
+	go srv.Serve()
+
+	conn, err := sftp.NewClientPipe(client, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		srv.Close()
+	})
+	c := newClient(nil, opts)
+	c.sftp, c.generation = conn, 1
+	return c
+}
+
+// slowWriter 每次写入前等待 delay，模拟响应缓慢的服务器
+type slowWriter struct {
+	sftp.FileWriter
+	delay time.Duration
+}
+
+func (w slowWriter) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	f, err := w.FileWriter.Filewrite(r)
+	if err != nil {
+		return nil, err
+	}
+	return slowWriterAt{f, w.delay}, nil
+}
+
+type slowWriterAt struct {
+	io.WriterAt
+	delay time.Duration
+}
+
+func (w slowWriterAt) WriteAt(b []byte, off int64) (int, error) {
+	time.Sleep(w.delay)
+	return w.WriterAt.WriteAt(b, off)
+}
+
+// TestHandleWriteAtOutlivesTimeout 超过 OpTimeout 的写入不能被放弃：放弃后写入仍在读取调用者的缓冲区，
+// 调用者复用缓冲区会把错误的数据写入服务器
+func TestHandleWriteAtOutlivesTimeout(t *testing.T) {
+	handlers := sftp.InMemHandler()
+	handlers.FilePut = slowWriter{handlers.FilePut, 100 * time.Millisecond}
+	c := serveTestClient(t, Options{OpTimeout: 20 * time.Millisecond}, handlers)
+	ctx := context.Background()
+
+	h, err := c.OpenFile(ctx, "/f", os.O_WRONLY|os.O_CREATE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := bytes.Repeat([]byte("A"), 64<<10)
+	data := append([]byte{}, want...)
+	n, err := h.WriteAt(ctx, data, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(data) {
+		t.Fatalf("short write: %d of %d", n, len(data))
+	}
+	// 模拟 bazil.org/fuse 在请求结束后复用缓冲区
+	copy(data, bytes.Repeat([]byte("Z"), len(data)))
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := c.ReadFile(ctx, "/f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("file holds %d bytes, %d of them 'A'", len(got), bytes.Count(got, []byte("A")))
+	}
+}
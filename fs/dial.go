@@ -0,0 +1,53 @@
+package fs
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+// Hop 跳板机，每个跳板机使用自己的认证配置
+type Hop struct {
+	Addr   string
+	Config *ssh.ClientConfig
+}
+
+// Dial 依次经过跳板机连接 server，后一跳通过前一跳的 ssh.Client 建立连接。
+// 关闭返回的 Client 时，中间的跳板机连接也会被关闭
+func Dial(config *ssh.ClientConfig, server string, jumps ...Hop) (*ssh.Client, error) {
+	hops := append(append([]Hop{}, jumps...), Hop{Addr: server, Config: config})
+
+	var client *ssh.Client
+	for _, hop := range hops {
+		if client == nil {
+			c, err := ssh.Dial("tcp", hop.Addr, hop.Config)
+			if err != nil {
+				return nil, fmt.Errorf("failed to dial %s: %v", hop.Addr, err)
+			}
+			client = c
+			continue
+		}
+
+		logrus.WithField("address", hop.Addr).Debug("dialing through jump host")
+		conn, err := client.Dial("tcp", hop.Addr)
+		if err != nil {
+			client.Close()
+			return nil, fmt.Errorf("failed to dial %s via %s: %v", hop.Addr, client.RemoteAddr(), err)
+		}
+		c, chans, reqs, err := ssh.NewClientConn(conn, hop.Addr, hop.Config)
+		if err != nil {
+			conn.Close()
+			client.Close()
+			return nil, fmt.Errorf("failed to dial %s via %s: %v", hop.Addr, client.RemoteAddr(), err)
+		}
+
+		next := ssh.NewClient(c, chans, reqs)
+		go func(prev *ssh.Client) {
+			next.Wait()
+			prev.Close()
+		}(client)
+		client = next
+	}
+	return client, nil
+}
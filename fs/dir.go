@@ -18,7 +18,6 @@ import (
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
 	"hash/crc64"
-	"log"
 	"os"
 	"path"
 	"path/filepath"
@@ -45,7 +44,7 @@ type Dir struct {
 var _ fs.Node = (*Dir)(nil)
 
 // NewRoot creates a new root and returns it
-func NewRoot(root string, c *sftp.Client) *Node {
+func NewRoot(root string, c *Client) *Node {
 	rnode := NewNode(c, 0, nil, root, true, true)
 	return rnode
 }
@@ -68,7 +67,7 @@ var _ fs.NodeSetattrer = (*Dir)(nil)
 // Setattr Dir
 func (d *Dir) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) error {
 	logrus.WithField("req", req).Debug("handling Dir.Setattr call")
-	return nil
+	return d.setattr(ctx, req, resp)
 }
 
 var _ fs.HandleReleaser = (*Dir)(nil)
@@ -83,7 +82,7 @@ func (d *Dir) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
 // Attr sets attrs on the given fuse.Attr
 func (d *Dir) Attr(ctx context.Context, a *fuse.Attr) error {
 	logrus.WithField("path", d.Path()).Debug("handling Dir.Attr call")
-	stat, err := d.sftp.Stat(d.Path())
+	stat, err := d.stat(ctx)
 	if err != nil {
 		return err
 	}
@@ -93,6 +92,7 @@ func (d *Dir) Attr(ctx context.Context, a *fuse.Attr) error {
 		a.Atime = time.Unix(int64(statT.Atime), 0)
 	}
 
+	a.Valid = d.sftp.opts.AttrTimeout
 	a.Inode = d.GetInode()
 	a.Mode = stat.Mode()
 	a.Mtime = stat.ModTime()
@@ -101,33 +101,46 @@ func (d *Dir) Attr(ctx context.Context, a *fuse.Attr) error {
 	return nil
 }
 
-var _ fs.NodeStringLookuper = (*Dir)(nil)
+var _ fs.NodeRequestLookuper = (*Dir)(nil)
 
 // Lookup looks up a path
-func (d *Dir) Lookup(ctx context.Context, name string) (fs.Node, error) {
-	logrus.WithField("name", name).Debug("handling Dir.Lookup call")
+func (d *Dir) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *fuse.LookupResponse) (fs.Node, error) {
+	logrus.WithField("name", req.Name).Debug("handling Dir.Lookup call")
+	name := req.Name
+	if d.reserved(name) {
+		return nil, fuse.ENOENT
+	}
 	//time.Sleep(10 * time.Second)
 	path := path.Join(d.Path(), name)
+	resp.EntryValid = d.sftp.opts.EntryTimeout
 
 	childNode, ok := d.Node.GetChild(name)
 	if ok {
-		if childNode.isdir {
-			return childNode.Dir, nil
+		return childNode.fsNode(), nil
+	}
+
+	// 目录内容仍在缓存中时，不在其中的名称不存在
+	if l, ok := d.listing(); ok {
+		if _, ok := l.names[name]; !ok {
+			return nil, fuse.ENOENT
 		}
-		return childNode.File, nil
+	}
+	if d.isNegative(name) {
+		return nil, fuse.ENOENT
 	}
 
-	// 本地缓存找不到对象则检查远程是否存在并添加到本地缓存
-	f, err := d.sftp.Stat(path)
-	logrus.Debugln("oooooo ", path, " ", err.Error())
+	// 本地缓存找不到对象则检查远程是否存在并添加到本地缓存，符号链接不跟随
+	f, err := d.sftp.Lstat(ctx, path)
 	if err != nil {
 		if err == os.ErrNotExist {
+			d.cacheNegative(name)
 			return nil, fuse.ENOENT
 		}
 		return nil, err
 	}
 	// 本地没有，远程有时，本地创建节点
-	childnode := NewNode(d.sftp, 0, d.Node, f.Name(), f.IsDir(), false)
+	childnode := d.newChild(f)
+	childnode.cacheAttr(f)
 
 	if f.IsDir() {
 		directories := []*Dir{childnode.Dir}
@@ -142,7 +155,14 @@ func (d *Dir) Lookup(ctx context.Context, name string) (fs.Node, error) {
 		files = append(*d.Files, files...)
 	}
 	d.Files = &files
-	return childnode.File, nil
+	return childnode.fsNode(), nil
+}
+
+// newChild 根据远程文件信息创建子节点
+func (d *Dir) newChild(f os.FileInfo) *Node {
+	node := NewNode(d.sftp, 0, d.Node, f.Name(), f.IsDir(), false)
+	node.islink = f.Mode()&os.ModeSymlink != 0
+	return node
 }
 
 var _ fs.NodeRemover = (*Dir)(nil)
@@ -152,6 +172,8 @@ func (d *Dir) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
 	logrus.WithField("current", d.Path()).WithField("req", req).Debug("handling Root.Remove call")
 	path := filepath.Join(d.Path(), req.Name)
 	rmnode, _ := d.GetChild(req.Name)
+	defer d.invalidateAttr()
+	defer d.invalidateEntries()
 
 	if req.Dir {
 		if rmnode.Dir.Dirs != nil {
@@ -165,7 +187,7 @@ func (d *Dir) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
 			}
 		}
 
-		if err := d.sftp.RemoveDirectory(path); err != nil {
+		if err := d.removeDir(ctx, path); err != nil {
 			return err
 		}
 		if rmnode != nil {
@@ -183,11 +205,18 @@ func (d *Dir) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
 		}
 		d.Dirs = &newDirs
 	} else {
-		if err := d.sftp.Remove(path); err != nil {
+		if err := d.sftp.Remove(ctx, path); err != nil {
 			return err
 		}
+		if d.xattrs() {
+			if err := removeXattrFile(ctx, d.sftp, sidecarPath(path)); err != nil {
+				return err
+			}
+		}
 
 		if rmnode != nil {
+			// 其他硬链接共享属性缓存，其 ctime 随之变化
+			rmnode.invalidateAttr()
 			rmnode.Remove()
 		}
 
@@ -213,8 +242,12 @@ func (d *Dir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
 	//log.Println(d.name, d.path, d.isroot, d.Path())
 	//d.Lock()
 	//defer d.Lock()
+	if l, ok := d.listing(); ok {
+		return l.dirents, nil
+	}
+
 	dirs := []fuse.Dirent{}
-	fs, err := d.sftp.ReadDir(path.Join(d.Path()))
+	fs, err := d.sftp.ReadDir(ctx, path.Join(d.Path()))
 	if err != nil {
 		return dirs, err
 	}
@@ -223,15 +256,23 @@ func (d *Dir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
 	files := []*File{}
 
 	for _, f := range fs {
+		if d.reserved(f.Name()) {
+			continue
+		}
 		t := fuse.DT_File
 		childnode, ok := d.Node.GetChild(f.Name())
 		if !ok {
-			childnode = NewNode(d.sftp, 0, d.Node, f.Name(), f.IsDir(), false)
+			childnode = d.newChild(f)
 		}
+		// 列目录的结果同时填充属性缓存，之后的 Lookup 和 Attr 无需再请求服务器
+		childnode.cacheAttr(f)
 		if f.IsDir() {
 			t = fuse.DT_Dir
 			directories = append(directories, childnode.Dir)
 		} else {
+			if childnode.islink {
+				t = fuse.DT_Link
+			}
 			files = append(files, childnode.File)
 		}
 
@@ -244,6 +285,7 @@ func (d *Dir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
 	}
 	d.Node.Dir.Dirs = &directories
 	d.Node.Dir.Files = &files
+	d.cacheListing(dirs)
 	return dirs, nil
 }
 
@@ -252,27 +294,31 @@ var _ fs.NodeMkdirer = (*Dir)(nil)
 // Mkdir Dir
 func (d *Dir) Mkdir(ctx context.Context, req *fuse.MkdirRequest) (fs.Node, error) {
 	logrus.Debug("handling Dir.Mkdir call")
+	if d.reserved(req.Name) {
+		return nil, fuse.EPERM
+	}
 	childnode, ok := d.GetChild(req.Name)
 	if ok {
-		if childnode.isdir {
-			return childnode.Dir, nil
-		}
-		return childnode.File, nil
+		d.expireEntry(req.Name)
+		return childnode.fsNode(), nil
 	}
 
 	newNode := NewNode(d.sftp, 0, d.Node, req.Name, true, false)
+	defer d.invalidateAttr()
+	defer d.invalidateEntries()
+	defer d.invalidateNegative(req.Name)
 
-	err := d.sftp.Mkdir(newNode.Path())
+	err := d.sftp.Mkdir(ctx, newNode.Path())
 	if err != nil {
 		return nil, err
 	}
 
-	err = d.sftp.Chmod(newNode.Path(), req.Mode)
+	err = d.sftp.Chmod(ctx, newNode.Path(), req.Mode)
 	if err != nil {
 		return nil, err
 	}
 
-	err = d.sftp.Chown(newNode.Path(), int(req.Uid), int(req.Gid))
+	err = d.sftp.Chown(ctx, newNode.Path(), int(req.Uid), int(req.Gid))
 	if err != nil {
 		return nil, err
 	}
@@ -283,6 +329,7 @@ func (d *Dir) Mkdir(ctx context.Context, req *fuse.MkdirRequest) (fs.Node, error
 	}
 	d.Dirs = &dirs
 
+	d.expireEntry(req.Name)
 	return newNode.Dir, nil
 }
 
@@ -291,24 +338,31 @@ var _ fs.NodeCreater = (*Dir)(nil)
 // Create Dir
 func (d *Dir) Create(ctx context.Context, req *fuse.CreateRequest, resp *fuse.CreateResponse) (fs.Node, fs.Handle, error) {
 	logrus.Debug("handling Dir.Create call")
+	if d.reserved(req.Name) {
+		return nil, nil, fuse.EPERM
+	}
+	resp.EntryValid = d.sftp.opts.EntryTimeout
 	node, ok := d.GetChild(req.Name)
 	if ok {
 		return node.File, node.File, nil
 	}
 
 	newNode := NewNode(d.sftp, 0, d.Node, req.Name, false, false)
+	defer d.invalidateAttr()
+	defer d.invalidateEntries()
+	defer d.invalidateNegative(req.Name)
 
-	file, err := d.sftp.Create(newNode.Path())
+	file, err := d.sftp.Create(ctx, newNode.Path())
 	if err != nil {
 		return nil, nil, err
 	}
 
-	err = d.sftp.Chmod(newNode.Path(), req.Mode)
+	err = d.sftp.Chmod(ctx, newNode.Path(), req.Mode)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	err = d.sftp.Chown(newNode.Path(), int(req.Uid), int(req.Gid))
+	err = d.sftp.Chown(ctx, newNode.Path(), int(req.Uid), int(req.Gid))
 	if err != nil {
 		return nil, nil, err
 	}
@@ -319,14 +373,19 @@ func (d *Dir) Create(ctx context.Context, req *fuse.CreateRequest, resp *fuse.Cr
 	}
 	d.Files = &files
 	newNode.File.file = file
+	newNode.handles.add(newNode.File)
 	newNode.File.Lock()
 	return newNode.File, newNode.File, nil
 }
 
 // Rename Dir
 func (d *Dir) Rename(ctx context.Context, req *fuse.RenameRequest, newDir fs.Node) error {
-	log.Println("Rename requested from", req.OldName, "to", req.NewName)
+	logrus.WithField("req", req).Debug("handling Dir.Rename call")
+	// 该版本的 bazil.org/fuse 不支持 RENAME2，内核会对 RENAME_NOREPLACE/RENAME_EXCHANGE 直接返回 EINVAL
 	newParentNode := newDir.(*Dir).Node
+	if newParentNode.reserved(req.NewName) {
+		return fuse.EPERM
+	}
 	opath := filepath.Join(d.Path(), req.OldName)
 	npath := filepath.Join(newParentNode.Path(), req.NewName)
 	d.Lock()
@@ -363,71 +422,154 @@ func (d *Dir) Rename(ctx context.Context, req *fuse.RenameRequest, newDir fs.Nod
 	// onode 为当前要 rename 的对象节点（目录或文件），当前目录为 d.Node 为 onode.parent
 	// newParentNode 新对象节点的父节点
 
-	// 变更 Node 信息
+	// 远程重命名成功后再变更 Node 信息，被覆盖的目标节点从缓存中删除
+	if err := d.sftp.Rename(ctx, opath, npath); err != nil {
+		return err
+	}
+
+	// 文件的扩展属性文件随文件移动，目录的扩展属性文件在目录内部。
+	// 移动失败时仍更新节点信息，使其与已完成的远程重命名一致
+	var xattrErr error
+	if d.xattrs() {
+		isdir := onode != nil && onode.isdir
+		if onode == nil {
+			info, err := d.sftp.Lstat(ctx, npath)
+			isdir = err == nil && info.IsDir()
+		}
+		if !isdir {
+			xattrErr = d.moveXattrs(ctx, opath, npath)
+		}
+	}
+
+	d.invalidateAttr()
+	d.invalidateEntries()
+	newParentNode.invalidateAttr()
+	newParentNode.invalidateEntries()
+	newParentNode.invalidateNegative(req.NewName)
+	// 两个路径上的文件都已改变，缓存的块按路径记录
+	if cache := d.sftp.cache; cache != nil {
+		cache.drop(opath)
+		cache.drop(npath)
+	}
+	if tnode, ok := newParentNode.GetChild(req.NewName); ok && tnode != onode {
+		tnode.invalidateAttr()
+		tnode.invalidateBlocks()
+		tnode.Remove()
+		newParentNode.Dir.removeEntry(tnode)
+	}
+	if onode == nil {
+		return xattrErr
+	}
+
+	onode.invalidateAttr()
 	d.Node.Rename(onode, newParentNode, req.NewName)
-	d.sftp.Rename(opath, npath)
+	d.removeEntry(onode)
+	newParentNode.Dir.addEntry(onode)
+	return xattrErr
+}
 
-	if newParentNode.inode == d.Node.inode {
-		return nil
+// removeDir 删除目录 p。目录中的扩展属性文件会使删除失败，因此先将其移到目录外，
+// 删除成功后再删除它，失败时移回原处，目录中还有其他文件时返回 ENOTEMPTY
+func (d *Dir) removeDir(ctx context.Context, p string) error {
+	if !d.xattrs() {
+		return d.sftp.RemoveDirectory(ctx, p)
 	}
 
-	// 移动到新目录
-	if onode.isdir {
-		// 清除旧父节点记录
-		if d.Dirs != nil {
-			directories := []*Dir{}
-			for _, dir := range *d.Dirs {
-				if dir.inode != onode.inode {
-					directories = append(directories, dir)
-				}
-			}
-			d.Dirs = &directories
+	entries, err := d.sftp.ReadDir(ctx, p)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Name() != xattrSuffix {
+			return fuse.Errno(syscall.ENOTEMPTY)
+		}
+	}
+	if len(entries) == 0 {
+		return d.sftp.RemoveDirectory(ctx, p)
+	}
+
+	inside, aside := path.Join(p, xattrSuffix), sidecarPath(p)
+	if err := d.sftp.Rename(ctx, inside, aside); err != nil {
+		return err
+	}
+	if err := d.sftp.RemoveDirectory(ctx, p); err != nil {
+		if restoreErr := d.sftp.Rename(ctx, aside, inside); restoreErr != nil {
+			logrus.WithError(restoreErr).WithField("path", p).Error("failed to restore directory xattrs")
 		}
+		return err
+	}
+	return removeXattrFile(ctx, d.sftp, aside)
+}
 
-		//新的父节点增加记录
-		if newParentNode.Dirs != nil {
-			directories := []*Dir{onode.Dir}
+// removeEntry 从目录的子节点列表中移除 node，硬链接共享 inode，因此按节点比较
+func (d *Dir) removeEntry(node *Node) {
+	if node.isdir {
+		if d.Dirs != nil {
+			directories := []*Dir{}
 			for _, dir := range *d.Dirs {
-				if dir.inode != onode.inode {
+				if dir.Node != node {
 					directories = append(directories, dir)
 				}
 			}
 			d.Dirs = &directories
 		}
-
-		return nil
+		return
 	}
-	// 清除旧父节点记录
+
 	if d.Files != nil {
 		files := []*File{}
 		for _, file := range *d.Files {
-			if file.inode != onode.inode {
+			if file.Node != node {
 				files = append(files, file)
 			}
 		}
 		d.Files = &files
 	}
+}
 
-	//新的父节点增加记录
-	if newParentNode.Files != nil {
-		files := []*File{onode.File}
-		for _, file := range *d.Files {
-			if file.inode != onode.inode {
-				files = append(files, file)
-			}
+// addEntry 将 node 加入目录的子节点列表
+func (d *Dir) addEntry(node *Node) {
+	if node.isdir {
+		directories := []*Dir{node.Dir}
+		if d.Dirs != nil {
+			directories = append(*d.Dirs, directories...)
 		}
-		d.Files = &files
+		d.Dirs = &directories
+		return
 	}
 
-	return nil
+	files := []*File{node.File}
+	if d.Files != nil {
+		files = append(*d.Files, files...)
+	}
+	d.Files = &files
 }
 
 var _ fs.NodeSymlinker = (*Dir)(nil)
 
 // Symlink Dir
 func (d *Dir) Symlink(ctx context.Context, req *fuse.SymlinkRequest) (fs.Node, error) {
-	logrus.WithField("req", req).Debugln("handling Dor.Symlink call")
-	return d.Dir, nil
+	logrus.WithField("req", req).Debugln("handling Dir.Symlink call")
+	if d.reserved(req.NewName) {
+		return nil, fuse.EPERM
+	}
+	if err := d.sftp.Symlink(ctx, req.Target, path.Join(d.Path(), req.NewName)); err != nil {
+		return nil, err
+	}
+	d.invalidateAttr()
+	d.invalidateEntries()
+	d.invalidateNegative(req.NewName)
+
+	newNode := NewNode(d.sftp, 0, d.Node, req.NewName, false, false)
+	newNode.islink = true
+
+	files := []*File{newNode.File}
+	if d.Files != nil {
+		files = append(*d.Files, files...)
+	}
+	d.Files = &files
+	d.expireEntry(req.NewName)
+	return newNode.Symlink, nil
 }
 
 var _ fs.NodeLinker = (*Dir)(nil)
@@ -435,5 +577,67 @@ var _ fs.NodeLinker = (*Dir)(nil)
 // Link Dir
 func (d *Dir) Link(ctx context.Context, req *fuse.LinkRequest, old fs.Node) (fs.Node, error) {
 	logrus.WithField("req", req).Debugln("handling Dir.Link call")
-	return d.Dir, nil
+	if d.reserved(req.NewName) {
+		return nil, fuse.EPERM
+	}
+	var onode *Node
+	switch n := old.(type) {
+	case *File:
+		onode = n.Node
+	case *Symlink:
+		onode = n.Node
+	default:
+		// 目录不能创建硬链接
+		return nil, fuse.EPERM
+	}
+
+	if err := d.sftp.Link(ctx, onode.Path(), path.Join(d.Path(), req.NewName)); err != nil {
+		return nil, err
+	}
+	d.invalidateAttr()
+	d.invalidateEntries()
+	d.invalidateNegative(req.NewName)
+	onode.invalidateAttr()
+
+	// 新名称与原节点共享 inode
+	newNode := onode.link(d.Node, req.NewName)
+	files := []*File{newNode.File}
+	if d.Files != nil {
+		files = append(*d.Files, files...)
+	}
+	d.Files = &files
+	d.expireEntry(req.NewName)
+	return newNode.fsNode(), nil
+}
+
+var _ fs.NodeGetxattrer = (*Dir)(nil)
+
+// Getxattr Dir
+func (d *Dir) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	logrus.WithField("req", req).Debug("handling Dir.Getxattr call")
+	return d.getxattr(ctx, req, resp)
+}
+
+var _ fs.NodeListxattrer = (*Dir)(nil)
+
+// Listxattr Dir
+func (d *Dir) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) error {
+	logrus.WithField("req", req).Debug("handling Dir.Listxattr call")
+	return d.listxattr(ctx, req, resp)
+}
+
+var _ fs.NodeSetxattrer = (*Dir)(nil)
+
+// Setxattr Dir
+func (d *Dir) Setxattr(ctx context.Context, req *fuse.SetxattrRequest) error {
+	logrus.WithField("name", req.Name).Debug("handling Dir.Setxattr call")
+	return d.setxattr(ctx, req)
+}
+
+var _ fs.NodeRemovexattrer = (*Dir)(nil)
+
+// Removexattr Dir
+func (d *Dir) Removexattr(ctx context.Context, req *fuse.RemovexattrRequest) error {
+	logrus.WithField("req", req).Debug("handling Dir.Removexattr call")
+	return d.removexattr(ctx, req)
 }
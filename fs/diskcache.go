@@ -0,0 +1,259 @@
+package fs
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// diskCache 本地磁盘上的文件块缓存，超过容量时淘汰最久未使用的块。
+// 每块保存为缓存子目录下的一个文件，文件的修改时间记录最近一次使用，重启后据此恢复淘汰顺序。
+// 修改时间只精确到秒，通过挂载点修改文件后按路径删除其块，不依赖版本的变化
+type diskCache struct {
+	dir   string
+	limit int64
+	size  int64
+	lru   *list.List // 最近使用的块在前
+	items map[string]*list.Element
+	paths map[string]map[string]struct{} // 本次运行中读写过的块，按远程路径索引
+	sync.Mutex
+}
+
+// cacheItem 缓存中的一块
+type cacheItem struct {
+	key  string
+	path string // 上次运行留下且本次尚未使用的块为空
+	size int64
+}
+
+// cacheMinAge 修改时间距今不足该时长的文件不缓存。sftp 的修改时间精确到秒，
+// 同一秒内大小不变的改写不会改变缓存版本
+const cacheMinAge = 2 * time.Second
+
+// cacheBlocksDir 块文件所在的子目录，缓存只读取和删除其中由 blockKey 命名的文件
+const cacheBlocksDir = "sshfs-blocks"
+
+// openDiskCache 打开 dir 下的块缓存，加载上次运行留下的块，容量为 limit 字节。
+// dir 可以是已有的目录，块保存在其中单独的子目录里
+func openDiskCache(dir string, limit int64) (*diskCache, error) {
+	dir = filepath.Join(expandHome(dir), cacheBlocksDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &diskCache{
+		dir:   dir,
+		limit: limit,
+		lru:   list.New(),
+		items: map[string]*list.Element{},
+		paths: map[string]map[string]struct{}{},
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ModTime().After(infos[j].ModTime())
+	})
+	for _, info := range infos {
+		name := info.Name()
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		// 写入中途退出留下的临时文件
+		if i := strings.IndexByte(name, '.'); i > 0 && strings.HasSuffix(name, ".tmp") && isBlockKey(name[:i]) {
+			os.Remove(filepath.Join(dir, name))
+			continue
+		}
+		if !isBlockKey(name) {
+			continue
+		}
+		c.items[name] = c.lru.PushBack(&cacheItem{key: name, size: info.Size()})
+		c.size += info.Size()
+	}
+
+	c.Lock()
+	c.evict()
+	c.Unlock()
+	logrus.WithFields(logrus.Fields{
+		"dir":    dir,
+		"blocks": c.lru.Len(),
+		"size":   c.size,
+	}).Debug("opened block cache")
+	return c, nil
+}
+
+// cacheVersion 标识远程文件的一个版本，大小或修改时间变化后旧版本的块不再命中
+func cacheVersion(p string, info os.FileInfo) string {
+	return fmt.Sprintf("%s\x00%d\x00%d", p, info.Size(), info.ModTime().UnixNano())
+}
+
+// blockKey 文件版本 version 第 index 块的缓存文件名
+func blockKey(version string, index int64) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%d", version, index)))
+	return hex.EncodeToString(sum[:])
+}
+
+// isBlockKey 判断 name 是否为 blockKey 生成的文件名
+func isBlockKey(name string) bool {
+	if len(name) != 2*sha256.Size {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil
+}
+
+// cacheable 判断修改时间为 mtime 的文件是否可以缓存
+func cacheable(mtime time.Time) bool {
+	return time.Since(mtime) >= cacheMinAge
+}
+
+// get 读取远程文件 p 缓存的块
+func (c *diskCache) get(p, key string) ([]byte, bool) {
+	c.Lock()
+	e, ok := c.items[key]
+	if ok {
+		c.lru.MoveToFront(e)
+		c.index(p, e.Value.(*cacheItem))
+	}
+	c.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	file := filepath.Join(c.dir, key)
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		logrus.WithError(err).WithField("block", key).Warn("dropping unreadable cached block")
+		c.Lock()
+		c.remove(key)
+		c.Unlock()
+		return nil, false
+	}
+	now := time.Now()
+	os.Chtimes(file, now, now)
+	return data, true
+}
+
+// put 缓存远程文件 p 的一块，先写入临时文件再重命名，中途退出不会留下不完整的块
+func (c *diskCache) put(p, key string, data []byte) {
+	size := int64(len(data))
+	if size == 0 || size > c.limit {
+		return
+	}
+	c.Lock()
+	_, ok := c.items[key]
+	c.Unlock()
+	if ok {
+		return
+	}
+
+	if err := writeFileAtomic(filepath.Join(c.dir, key), data); err != nil {
+		logrus.WithError(err).WithField("block", key).Warn("failed to cache block")
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.items[key]; ok {
+		return
+	}
+	item := &cacheItem{key: key, size: size}
+	c.items[key] = c.lru.PushFront(item)
+	c.index(p, item)
+	c.size += size
+	c.evict()
+}
+
+// index 记录块属于远程文件 p，调用时需持有锁
+func (c *diskCache) index(p string, item *cacheItem) {
+	if item.path != "" {
+		return
+	}
+	item.path = p
+	if c.paths[p] == nil {
+		c.paths[p] = map[string]struct{}{}
+	}
+	c.paths[p][item.key] = struct{}{}
+}
+
+// drop 删除远程文件 p 的所有块，在通过挂载点修改文件后调用
+func (c *diskCache) drop(p string) {
+	c.Lock()
+	defer c.Unlock()
+	for key := range c.paths[p] {
+		c.remove(key)
+	}
+}
+
+// remove 删除缓存的块，调用时需持有锁
+func (c *diskCache) remove(key string) {
+	e, ok := c.items[key]
+	if !ok {
+		return
+	}
+	item := c.lru.Remove(e).(*cacheItem)
+	delete(c.items, key)
+	if keys := c.paths[item.path]; keys != nil {
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(c.paths, item.path)
+		}
+	}
+	c.size -= item.size
+	os.Remove(filepath.Join(c.dir, key))
+}
+
+// evict 淘汰最久未使用的块直到不超过容量，调用时需持有锁
+func (c *diskCache) evict() {
+	for c.size > c.limit && c.lru.Len() > 0 {
+		c.remove(c.lru.Back().Value.(*cacheItem).key)
+	}
+}
+
+// invalidateBlocks 删除节点在磁盘缓存中的块，在通过挂载点修改文件内容后调用。
+// 已打开的句柄此后不再使用磁盘缓存，正在读取的块也不会再写入缓存
+func (n *Node) invalidateBlocks() {
+	atomic.AddUint64(n.changes, 1)
+	if n.sftp.cache != nil {
+		n.sftp.cache.drop(n.Path())
+	}
+}
+
+// cached 判断句柄是否使用磁盘缓存：打开时文件足够旧，且打开后没有通过挂载点修改
+func (f *File) cached() bool {
+	return f.version != "" && atomic.LoadUint64(f.changes) == f.opened
+}
+
+// writeFileAtomic 通过同目录下的临时文件写入 p
+func writeFileAtomic(p string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(p), filepath.Base(p)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = os.Rename(tmp.Name(), p); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package fs
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/pkg/sftp"
+)
+
+// fixedMtime 报告固定修改时间的服务器，模拟同一秒内的改写
+type fixedMtime struct {
+	sftp.FileLister
+	mtime time.Time
+}
+
+func (l fixedMtime) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	lister, err := l.FileLister.Filelist(r)
+	if err != nil {
+		return nil, err
+	}
+	return fixedMtimeLister{lister, l.mtime}, nil
+}
+
+type fixedMtimeLister struct {
+	sftp.ListerAt
+	mtime time.Time
+}
+
+func (l fixedMtimeLister) ListAt(infos []os.FileInfo, off int64) (int, error) {
+	n, err := l.ListerAt.ListAt(infos, off)
+	for i := range infos[:n] {
+		infos[i] = fixedMtimeInfo{infos[i], l.mtime}
+	}
+	return n, err
+}
+
+type fixedMtimeInfo struct {
+	os.FileInfo
+	mtime time.Time
+}
+
+func (i fixedMtimeInfo) ModTime() time.Time { return i.mtime }
+
+// TestDiskCacheDropsWrittenFile 通过挂载点改写文件后，即使大小和修改时间不变，也不能再读到缓存的旧块
+func TestDiskCacheDropsWrittenFile(t *testing.T) {
+	ctx := context.Background()
+	handlers := sftp.InMemHandler()
+	handlers.FileList = fixedMtime{handlers.FileList, time.Now().Add(-time.Hour)}
+	c := serveTestClient(t, Options{}, handlers)
+	cache, err := openDiskCache(t.TempDir(), 64<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.cache = cache
+
+	if err := c.WriteFile(ctx, "/data", bytes.Repeat([]byte("a"), blockSize)); err != nil {
+		t.Fatal(err)
+	}
+
+	root := NewRoot("/", c)
+	node, err := root.Dir.Lookup(ctx, &fuse.LookupRequest{Name: "data"}, &fuse.LookupResponse{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	open := func(flags fuse.OpenFlags) *File {
+		handle, err := node.(*File).Open(ctx, &fuse.OpenRequest{Flags: flags}, &fuse.OpenResponse{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return handle.(*File)
+	}
+	read := func() []byte {
+		f := open(fuse.OpenReadOnly)
+		defer f.Release(ctx, &fuse.ReleaseRequest{})
+		resp := &fuse.ReadResponse{}
+		if err := f.Read(ctx, &fuse.ReadRequest{Size: blockSize}, resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp.Data
+	}
+
+	read()
+	if cache.lru.Len() != 1 {
+		t.Fatalf("%d blocks cached, want 1", cache.lru.Len())
+	}
+
+	// 同样大小的改写，修改时间不变，版本与缓存的块相同
+	data := bytes.Repeat([]byte("b"), blockSize)
+	writer := open(fuse.OpenWriteOnly)
+	if err := writer.Write(ctx, &fuse.WriteRequest{Data: data}, &fuse.WriteResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Release(ctx, &fuse.ReleaseRequest{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := read(); !bytes.Equal(got, data) {
+		t.Fatalf("read %q... after the write, want %q...", got[:8], data[:8])
+	}
+}
@@ -0,0 +1,78 @@
+package fs
+
+import (
+	"strconv"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/sirupsen/logrus"
+)
+
+// kernelEntryValid bazil.org/fuse 为 Mkdir、Symlink 和 Link 返回的目录项设置的内核缓存时间，这些调用无法修改它
+const kernelEntryValid = time.Minute
+
+// dirListing 列目录的结果
+type dirListing struct {
+	dirents []fuse.Dirent
+	names   map[string]struct{}
+}
+
+// listing 返回目录缓存的内容
+func (d *Dir) listing() (*dirListing, bool) {
+	l, ok := d.sftp.entries.Get(strconv.FormatUint(d.inode, 10))
+	if !ok {
+		return nil, false
+	}
+	return l.(*dirListing), true
+}
+
+// cacheListing 缓存列目录的结果，EntryTimeout 为 0 时不缓存
+func (d *Dir) cacheListing(dirents []fuse.Dirent) {
+	ttl := d.sftp.opts.EntryTimeout
+	if ttl <= 0 {
+		return
+	}
+	l := &dirListing{dirents: dirents, names: make(map[string]struct{}, len(dirents))}
+	for _, dirent := range dirents {
+		l.names[dirent.Name] = struct{}{}
+	}
+	d.sftp.entries.Set(strconv.FormatUint(d.inode, 10), l, ttl)
+}
+
+// invalidateEntries 丢弃目录的缓存内容，在通过挂载点增删目录项后调用
+func (n *Node) invalidateEntries() {
+	n.sftp.entries.Delete(strconv.FormatUint(n.inode, 10))
+}
+
+// isNegative 判断名称是否在不久前查找过且不存在
+func (d *Dir) isNegative(name string) bool {
+	_, ok := d.sftp.negatives.Get(strconv.FormatUint(d.inode, 10) + "_" + name)
+	return ok
+}
+
+// cacheNegative 记录不存在的名称，NegativeTimeout 为 0 时不记录
+func (d *Dir) cacheNegative(name string) {
+	if ttl := d.sftp.opts.NegativeTimeout; ttl > 0 {
+		d.sftp.negatives.Set(strconv.FormatUint(d.inode, 10)+"_"+name, struct{}{}, ttl)
+	}
+}
+
+// invalidateNegative 清除名称的不存在记录，在通过挂载点创建该名称时调用
+func (n *Node) invalidateNegative(name string) {
+	n.sftp.negatives.Delete(strconv.FormatUint(n.inode, 10) + "_" + name)
+}
+
+// expireEntry 让内核在 EntryTimeout 后丢弃新建的目录项 name。
+// EntryTimeout 不短于框架的默认值时不处理，内核提前重新查找时 Lookup 会按 EntryTimeout 返回
+func (d *Dir) expireEntry(name string) {
+	server, ttl := d.sftp.server, d.sftp.opts.EntryTimeout
+	if server == nil || ttl >= kernelEntryValid {
+		return
+	}
+	// 内核在创建期间持有目录锁，通知必须在响应发出后异步发送
+	time.AfterFunc(ttl, func() {
+		if err := server.InvalidateEntry(d, name); err != nil && err != fuse.ErrNotCached {
+			logrus.WithError(err).WithField("name", name).Debug("failed to invalidate entry")
+		}
+	})
+}
@@ -6,17 +6,20 @@ import (
 	"context"
 	"github.com/pkg/sftp"
 	"github.com/sirupsen/logrus"
-	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // File Node
 type File struct {
 	*Node
-	create  bool
-	file    *sftp.File
-	writing bool
+	create    bool
+	file      *Handle
+	readahead *readAhead
+	version   string // 打开时远程文件的版本，为空时不使用磁盘缓存
+	opened    uint64 // 打开时节点的修改次数
+	writing   bool
 	sync.Mutex
 }
 
@@ -25,7 +28,7 @@ var _ fs.Node = (*File)(nil)
 // Attr File
 func (f *File) Attr(ctx context.Context, a *fuse.Attr) error {
 	logrus.Debug("handling File.Attr call")
-	stat, err := f.sftp.Stat(f.Path())
+	stat, err := f.stat(ctx)
 	if err != nil {
 		return err
 	}
@@ -35,9 +38,14 @@ func (f *File) Attr(ctx context.Context, a *fuse.Attr) error {
 		a.Atime = time.Unix(int64(statT.Atime), 0)
 	}
 
+	a.Valid = f.sftp.opts.AttrTimeout
 	a.Inode = f.GetInode()
 	a.Mode = stat.Mode()
 	a.Size = uint64(stat.Size())
+	// 尚在写缓冲中的数据已经扩展了文件
+	if size := f.bufferedSize(); size > stat.Size() {
+		a.Size = uint64(size)
+	}
 	a.Ctime = stat.ModTime()
 	a.Mtime = stat.ModTime()
 	return nil
@@ -48,11 +56,7 @@ var _ fs.NodeSetattrer = (*File)(nil)
 // Setattr File
 func (f *File) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) error {
 	logrus.WithField("req", req).Debug("handling File.Setattr call")
-	if req.Valid.Size() {
-		resp.Attr.Size = req.Size
-		return f.sftp.Truncate(f.Path(), int64(req.Size))
-	}
-	return nil
+	return f.setattr(ctx, req, resp)
 }
 
 var _ fs.NodeOpener = (*File)(nil)
@@ -60,12 +64,8 @@ var _ fs.NodeOpener = (*File)(nil)
 // Open File
 func (f *File) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
 	logrus.WithField("req", req).Debug("handling File.Open call")
-	// Unsupported flags
-	if req.Flags&fuse.OpenAppend == fuse.OpenAppend {
-		return nil, fuse.ENOTSUP
-	}
-
-	file, err := f.sftp.OpenFile(f.Path(), int(req.Flags))
+	// O_APPEND 同时传给服务器（SSH_FXF_APPEND），写入时再以远程文件大小作为偏移
+	file, err := f.sftp.OpenFile(ctx, f.Path(), int(req.Flags))
 	if err != nil {
 		return nil, err
 	}
@@ -75,17 +75,28 @@ func (f *File) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenR
 		Node: f.Node,
 	}
 	fh.Lock()
+	if !req.Flags.IsWriteOnly() {
+		fh.readahead = newReadAhead(fh, f.sftp.opts.ReadAhead, f.sftp.opts.ReadAheadMemory)
+	}
+	f.handles.add(fh)
 
-	if req.Flags.IsReadOnly() {
-		return fh, err
+	// 只读句柄使用磁盘缓存，以打开时远程文件的大小和修改时间区分版本。
+	// 刚修改过的文件可能在同一秒内再次改写而版本不变，不缓存
+	if f.sftp.cache != nil && req.Flags.IsReadOnly() {
+		fh.opened = atomic.LoadUint64(f.changes)
+		f.commitWrites(ctx)
+		if info, err := f.sftp.Lstat(ctx, f.Path()); err == nil {
+			f.cacheAttr(info)
+			if cacheable(info.ModTime()) {
+				fh.version = cacheVersion(f.Path(), info)
+			}
+		}
 	}
 
-	if req.Flags.IsWriteOnly() {
+	if !req.Flags.IsReadOnly() {
 		resp.Flags = fuse.OpenPurgeAttr
-		return fh, nil
 	}
-
-	return nil, fuse.ENOTSUP
+	return fh, nil
 }
 
 var _ fs.Handle = (*File)(nil)
@@ -95,18 +106,25 @@ var _ fs.HandleReader = (*File)(nil)
 // Read File
 func (f *File) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
 	logrus.WithField("req", req).Debug("handling File.Read call")
-	// TODO: 大文件按照 req.Offset 分块读取
 	if f.file == nil {
 		var err error
-		f.file, err = f.sftp.OpenFile(f.Path(), int(req.Flags))
+		f.file, err = f.sftp.OpenFile(ctx, f.Path(), int(req.Flags))
 		if err != nil {
 			return err
 		}
+		f.handles.add(f)
 	}
 
-	f.file.Seek(req.Offset, 0)
+	// 先提交缓冲的写入，读取才能看到它们
+	f.commitWrites(ctx)
+
+	// 按块并发读取，只返回文件末尾前的数据
 	resp.Data = make([]byte, req.Size)
-	f.file.Read(resp.Data)
+	n, err := f.readAt(ctx, resp.Data, req.Offset)
+	if err != nil {
+		return err
+	}
+	resp.Data = resp.Data[:n]
 	return nil
 }
 
@@ -117,22 +135,69 @@ func (f *File) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.Wri
 	logrus.Debug("handling File.Write call")
 	var err error
 	if f.file == nil {
-		f.file, err = f.sftp.OpenFile(f.Path(), int(req.FileFlags)|int(req.Flags))
+		f.file, err = f.sftp.OpenFile(ctx, f.Path(), int(req.FileFlags)|int(req.Flags))
 		if err != nil {
 			return err
 		}
+		f.handles.add(f)
+	}
+
+	var n int
+	if req.FileFlags&fuse.OpenAppend != 0 {
+		// 只有关闭 WritebackCache 时内核才会传递 O_APPEND，否则追加写由内核换算为普通偏移写入。
+		// 同一文件的追加写需要串行，否则会以相同的远程大小作为偏移而相互覆盖
+		f.appending.Lock()
+		f.commitWrites(ctx)
+		n, err = f.file.Append(ctx, req.Data)
+		f.appending.Unlock()
+	} else {
+		n, err = f.file.BufferAt(ctx, req.Data, req.Offset)
 	}
-	//stat, err := f.file.Stat()
-	_, err = f.file.Write(req.Data)
-	resp.Size = len(req.Data)
+	// 所有句柄在写入前预读或缓存的块都可能已过期
+	f.resetReadAhead()
+	f.invalidateBlocks()
+	f.invalidateAttr()
+	resp.Size = n
 	return err
 }
 
 var _ fs.NodeFsyncer = (*File)(nil)
 
-// Fsync File
+// fsyncUnsupported 只在第一次遇到服务器不支持 fsync 时警告
+var fsyncUnsupported sync.Once
+
+// Fsync File，提交节点上所有句柄缓冲的写入，再对每个句柄调用 fsync@openssh.com
 func (f *File) Fsync(ctx context.Context, req *fuse.FsyncRequest) error {
 	logrus.Debug("handling File.Fsync call")
+	handles := f.handles.list()
+
+	// 先提交所有句柄，某个句柄出错也不能让其他句柄的数据留在缓冲中
+	var flushErr error
+	for _, h := range handles {
+		if err := h.file.Flush(ctx); err != nil && flushErr == nil {
+			flushErr = err
+		}
+	}
+	if flushErr != nil {
+		return flushErr
+	}
+
+	mode := f.sftp.opts.Fsync
+	if mode == FsyncDisabled {
+		return nil
+	}
+	for _, h := range handles {
+		err := h.file.Sync(ctx)
+		if err == fuse.ENOTSUP && mode != FsyncStrict {
+			fsyncUnsupported.Do(func() {
+				logrus.Warn("server does not support fsync@openssh.com, fsync only waits for writes to be acknowledged")
+			})
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -142,7 +207,11 @@ var _ fs.HandleReleaser = (*File)(nil)
 func (f *File) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
 	logrus.Debug("handling File.Release call", f.Path())
 	var err error
+	if f.readahead != nil {
+		f.readahead.stop()
+	}
 	if f.file != nil {
+		f.handles.remove(f)
 		err = f.file.Close()
 	}
 	f.Unlock()
@@ -151,8 +220,43 @@ func (f *File) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
 
 var _ fs.HandleFlusher = (*File)(nil)
 
-// Flush File
+// Flush File，提交句柄上缓冲的写入
 func (f *File) Flush(ctx context.Context, req *fuse.FlushRequest) error {
-	log.Println("Flushing file", f.Path())
-	return nil
+	logrus.WithField("path", f.Path()).Debug("handling File.Flush call")
+	if f.file == nil {
+		return nil
+	}
+	return f.file.Flush(ctx)
+}
+
+var _ fs.NodeGetxattrer = (*File)(nil)
+
+// Getxattr File
+func (f *File) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	logrus.WithField("req", req).Debug("handling File.Getxattr call")
+	return f.getxattr(ctx, req, resp)
+}
+
+var _ fs.NodeListxattrer = (*File)(nil)
+
+// Listxattr File
+func (f *File) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) error {
+	logrus.WithField("req", req).Debug("handling File.Listxattr call")
+	return f.listxattr(ctx, req, resp)
+}
+
+var _ fs.NodeSetxattrer = (*File)(nil)
+
+// Setxattr File
+func (f *File) Setxattr(ctx context.Context, req *fuse.SetxattrRequest) error {
+	logrus.WithField("name", req.Name).Debug("handling File.Setxattr call")
+	return f.setxattr(ctx, req)
+}
+
+var _ fs.NodeRemovexattrer = (*File)(nil)
+
+// Removexattr File
+func (f *File) Removexattr(ctx context.Context, req *fuse.RemovexattrRequest) error {
+	logrus.WithField("req", req).Debug("handling File.Removexattr call")
+	return f.removexattr(ctx, req)
 }
@@ -0,0 +1,148 @@
+package fs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"bazil.org/fuse"
+)
+
+// TestFileWriteConcurrentAppend 多个句柄同时追加写同一文件，任何一次写入都不能覆盖其他写入。
+// 挂载时只有关闭 WritebackCache 内核才会发送带 O_APPEND 的写入，这里直接调用 File.Write
+func TestFileWriteConcurrentAppend(t *testing.T) {
+	const (
+		writers = 8
+		records = 50
+	)
+	ctx := context.Background()
+	c := newTestClient(t, Options{})
+	if err := c.WriteFile(ctx, "/log", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	root := NewRoot("/", c)
+	node, err := root.Dir.Lookup(ctx, &fuse.LookupRequest{Name: "log"}, &fuse.LookupResponse{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for w := 0; w < writers; w++ {
+		flags := fuse.OpenWriteOnly | fuse.OpenAppend
+		handle, err := node.(*File).Open(ctx, &fuse.OpenRequest{Flags: flags}, &fuse.OpenResponse{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		f := handle.(*File)
+
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			defer f.Release(ctx, &fuse.ReleaseRequest{})
+			for r := 0; r < records; r++ {
+				// 内核给出的偏移是过期的本地文件大小，追加写必须忽略它
+				req := &fuse.WriteRequest{
+					Offset:    0,
+					Data:      []byte(fmt.Sprintf("writer %02d record %03d\n", w, r)),
+					FileFlags: flags,
+				}
+				resp := &fuse.WriteResponse{}
+				if err := f.Write(ctx, req, resp); err != nil {
+					errs <- err
+					return
+				}
+				if resp.Size != len(req.Data) {
+					errs <- fmt.Errorf("short write: %d of %d", resp.Size, len(req.Data))
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	data, err := c.ReadFile(ctx, "/log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.Split(bytes.TrimSuffix(data, []byte("\n")), []byte("\n"))
+	if len(lines) != writers*records {
+		t.Fatalf("got %d records, want %d", len(lines), writers*records)
+	}
+	seen := map[string]bool{}
+	for _, line := range lines {
+		if seen[string(line)] {
+			t.Fatalf("duplicate record %q", line)
+		}
+		seen[string(line)] = true
+	}
+	for w := 0; w < writers; w++ {
+		for r := 0; r < records; r++ {
+			if line := fmt.Sprintf("writer %02d record %03d", w, r); !seen[line] {
+				t.Fatalf("missing record %q", line)
+			}
+		}
+	}
+}
+
+// TestFileWriteResetsReadAhead 一个句柄写入后，其他句柄不能再返回写入前预读的块
+func TestFileWriteResetsReadAhead(t *testing.T) {
+	ctx := context.Background()
+	c := newTestClient(t, Options{ReadAhead: 4 * blockSize, ReadAheadMemory: 64 << 20})
+	if err := c.WriteFile(ctx, "/data", bytes.Repeat([]byte("a"), 8*blockSize)); err != nil {
+		t.Fatal(err)
+	}
+
+	root := NewRoot("/", c)
+	node, err := root.Dir.Lookup(ctx, &fuse.LookupRequest{Name: "data"}, &fuse.LookupResponse{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	open := func(flags fuse.OpenFlags) *File {
+		handle, err := node.(*File).Open(ctx, &fuse.OpenRequest{Flags: flags}, &fuse.OpenResponse{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		f := handle.(*File)
+		t.Cleanup(func() { f.Release(ctx, &fuse.ReleaseRequest{}) })
+		return f
+	}
+	read := func(f *File, off int64) []byte {
+		resp := &fuse.ReadResponse{}
+		if err := f.Read(ctx, &fuse.ReadRequest{Offset: off, Size: blockSize}, resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp.Data
+	}
+
+	// 两次顺序读取后，第 2 块已在后台预读完成
+	reader := open(fuse.OpenReadOnly)
+	read(reader, 0)
+	read(reader, blockSize)
+	p := reader.readahead.lookup(2)
+	if p == nil {
+		t.Fatal("block 2 was not prefetched")
+	}
+	<-p.done
+
+	writer := open(fuse.OpenWriteOnly)
+	data := bytes.Repeat([]byte("b"), blockSize)
+	resp := &fuse.WriteResponse{}
+	if err := writer.Write(ctx, &fuse.WriteRequest{Offset: 2 * blockSize, Data: data}, resp); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Flush(ctx, &fuse.FlushRequest{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := read(reader, 2*blockSize); !bytes.Equal(got, data) {
+		t.Fatalf("read %q... after the write, want %q...", got[:8], data[:8])
+	}
+}
@@ -30,17 +30,89 @@ import (
 
 // SSHFS is a ssh filesystem
 type SSHFS struct {
-	*sftp.Client
+	*Client
 	root       string
 	conn       *fuse.Conn
 	mountpoint string
 }
 
+// 服务器不支持 statvfs@openssh.com 时 Statfs 的处理方式
+const (
+	// StatfsFallbackFixed 返回固定的统计信息，所有空间均可用
+	StatfsFallbackFixed = "fixed"
+	// StatfsFallbackError 返回 ENOTSUP
+	StatfsFallbackError = "error"
+)
+
+// 服务器不支持 fsync@openssh.com 时 Fsync 的处理方式
+const (
+	// FsyncStrict 返回 ENOTSUP
+	FsyncStrict = "strict"
+	// FsyncBestEffort 记录一次警告后返回成功
+	FsyncBestEffort = "best-effort"
+	// FsyncDisabled 不向服务器发送 fsync，总是返回成功
+	FsyncDisabled = "disabled"
+)
+
+// fixedStatfs 服务器不支持 statvfs 时返回的统计信息：1 PiB 空间和 2^32 个 inode，全部可用
+var fixedStatfs = fuse.StatfsResponse{
+	Blocks:  1 << 38,
+	Bfree:   1 << 38,
+	Bavail:  1 << 38,
+	Files:   1 << 32,
+	Ffree:   1 << 32,
+	Bsize:   4096,
+	Frsize:  4096,
+	Namelen: 255,
+}
+
+// Options 挂载选项
+type Options struct {
+	// Jumps 依次经过的跳板机
+	Jumps []Hop
+	// Reconnect 连接断开后自动重连
+	Reconnect bool
+	// ReconnectTimeout 文件系统调用等待重连的最长时间
+	ReconnectTimeout time.Duration
+	// KeepaliveInterval 发送保活请求的间隔，为 0 时不发送
+	KeepaliveInterval time.Duration
+	// KeepaliveCountMax 连续未回复的保活请求达到该次数时断开连接
+	KeepaliveCountMax int
+	// OpTimeout 单次 sftp 操作的最长时间，超时返回 ETIMEDOUT，为 0 时不限制
+	OpTimeout time.Duration
+	// StatfsFallback 服务器不支持 statvfs 时的处理方式，StatfsFallbackFixed 或 StatfsFallbackError
+	StatfsFallback string
+	// Fsync Fsync 的处理方式，FsyncStrict、FsyncBestEffort 或 FsyncDisabled
+	Fsync string
+	// Xattr 扩展属性的存储方式，XattrNone 或 XattrSidecar
+	Xattr string
+	// MaxRequests 单个文件同时进行的 sftp 请求数，为 0 时使用 sftp 的默认值
+	MaxRequests int
+	// ReadAhead 顺序读取时每个句柄预读的字节数，为 0 时不预读
+	ReadAhead int64
+	// ReadAheadMemory 所有句柄预读数据占用内存的上限
+	ReadAheadMemory int64
+	// AttrTimeout 文件属性在本地和内核中的缓存时间，为 0 时不缓存
+	AttrTimeout time.Duration
+	// EntryTimeout 目录内容和名称查找结果在本地和内核中的缓存时间，为 0 时不缓存
+	EntryTimeout time.Duration
+	// NegativeTimeout 不存在的名称在本地的缓存时间，为 0 时不缓存
+	NegativeTimeout time.Duration
+	// CacheDir 文件块的磁盘缓存目录，为空时不缓存
+	CacheDir string
+	// CacheSize 磁盘缓存的容量
+	CacheSize int64
+	// WriteBuffer 每个可写句柄缓冲的字节数，连续的写入合并后再发送，为 0 时直接写入
+	WriteBuffer int
+	// WritebackCache 启用内核的回写缓存。启用时内核自行处理 O_APPEND，追加写以普通偏移写入到达
+	WritebackCache bool
+}
+
 // NewSftp sftp
-func NewSftp(config *ssh.ClientConfig, server string) (*sftp.Client, error) {
-	conn, err := ssh.Dial("tcp", server, config)
+func NewSftp(config *ssh.ClientConfig, server string, jumps ...Hop) (*sftp.Client, error) {
+	conn, err := Dial(config, server, jumps...)
 	if err != nil {
-		panic("Failed to dial: " + err.Error())
+		return nil, err
 	}
 	return sftp.NewClient(conn)
 }
@@ -48,11 +120,31 @@ func NewSftp(config *ssh.ClientConfig, server string) (*sftp.Client, error) {
 var _ fs.FS = (*SSHFS)(nil)
 
 // New returns a new SSHFS
-func New(config *ssh.ClientConfig, mountpoint, server, root string) (*SSHFS, error) {
-	client, err := NewSftp(config, server)
+func New(config *ssh.ClientConfig, mountpoint, server, root string, opts Options) (*SSHFS, error) {
+	client, err := NewClient(func() (*ssh.Client, error) {
+		return Dial(config, server, opts.Jumps...)
+	}, opts)
 	if err != nil {
 		return nil, err
 	}
+
+	if opts.CacheDir != "" {
+		client.cache, err = openDiskCache(opts.CacheDir, opts.CacheSize)
+		if err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+
+	// 未指定远程目录时挂载登录用户的主目录
+	if root == "" {
+		root, err = client.Getwd(context.Background())
+		if err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+
 	sshfs := &SSHFS{
 		Client:     client,
 		root:       root,
@@ -77,17 +169,19 @@ func (v *SSHFS) Mount() error {
 	}
 	InitInode(stat.Ino)
 
-	v.conn, err = fuse.Mount(
-		v.mountpoint,
+	options := []fuse.MountOption{
 		fuse.FSName("ssh"),
 		fuse.VolumeName("ssh"),
 		fuse.AsyncRead(),
-		fuse.WritebackCache(),
 		fuse.DefaultPermissions(),
 		fuse.AllowDev(),
 		fuse.AllowOther(),
 		//fuse.AllowRoot(),
-	)
+	}
+	if v.opts.WritebackCache {
+		options = append(options, fuse.WritebackCache())
+	}
+	v.conn, err = fuse.Mount(v.mountpoint, options...)
 
 	logrus.Debug("created conn")
 	if err != nil {
@@ -95,7 +189,8 @@ func (v *SSHFS) Mount() error {
 	}
 
 	logrus.Debug("starting to serve")
-	return fs.Serve(v.conn, v)
+	v.server = fs.New(v.conn, nil)
+	return v.server.Serve(v)
 }
 
 // Unmount the FS
@@ -113,6 +208,7 @@ func (v *SSHFS) Unmount() error {
 	if err != nil {
 		return err
 	}
+	v.Client.Close()
 
 	logrus.Debug("closed connection, waiting for ready")
 	<-v.conn.Ready
@@ -136,6 +232,23 @@ var _ fs.FSStatfser = (*SSHFS)(nil)
 // Statfs sshfs
 func (v *SSHFS) Statfs(ctx context.Context, req *fuse.StatfsRequest, resp *fuse.StatfsResponse) error {
 	logrus.Debug("handling SSHFS.Statfs call")
+	stat, err := v.StatVFS(ctx, v.root)
+	if err == fuse.ENOTSUP && v.opts.StatfsFallback != StatfsFallbackError {
+		*resp = fixedStatfs
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	resp.Blocks = stat.Blocks
+	resp.Bfree = stat.Bfree
+	resp.Bavail = stat.Bavail
+	resp.Files = stat.Files
+	resp.Ffree = stat.Ffree
+	resp.Bsize = uint32(stat.Bsize)
+	resp.Frsize = uint32(stat.Frsize)
+	resp.Namelen = uint32(stat.Namemax)
 	return nil
 }
 
@@ -0,0 +1,119 @@
+package fs
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Responder 回答 keyboard-interactive 认证的问题，用于一次性口令等多因素登录
+type Responder struct {
+	// Answers 固定答案，"提示=答案" 格式按提示内容匹配（不区分大小写），
+	// 不含 "=" 的答案用于其他所有问题
+	Answers []string
+	// TOTPSecret TOTP 密钥，用于回答验证码类问题
+	TOTPSecret []byte
+	// Password 用于回答密码类问题
+	Password string
+	// Prompt 交互式提问，为空时不提问
+	Prompt func(question string, echo bool) (string, error)
+}
+
+// enabled 是否有任何可用的回答来源
+func (r *Responder) enabled() bool {
+	return r != nil && (len(r.Answers) > 0 || len(r.TOTPSecret) > 0 || r.Password != "" || r.Prompt != nil)
+}
+
+// Challenge 实现 ssh.KeyboardInteractiveChallenge
+func (r *Responder) Challenge(name, instruction string, questions []string, echos []bool) ([]string, error) {
+	if instruction != "" {
+		logrus.WithField("name", name).Info(instruction)
+	}
+
+	answers := make([]string, len(questions))
+	for i, question := range questions {
+		answer, err := r.answer(question, echos[i])
+		if err != nil {
+			return nil, err
+		}
+		answers[i] = answer
+	}
+	return answers, nil
+}
+
+// answer 依次尝试固定答案、TOTP、密码和交互式提问
+func (r *Responder) answer(question string, echo bool) (string, error) {
+	q := strings.ToLower(question)
+
+	fallback, ok := "", false
+	for _, answer := range r.Answers {
+		i := strings.Index(answer, "=")
+		if i < 0 {
+			fallback, ok = answer, true
+			continue
+		}
+		if strings.Contains(q, strings.ToLower(answer[:i])) {
+			return answer[i+1:], nil
+		}
+	}
+
+	if len(r.TOTPSecret) > 0 && isOTPQuestion(q) {
+		return TOTP(r.TOTPSecret, time.Now()), nil
+	}
+	if r.Password != "" && strings.Contains(q, "password") {
+		return r.Password, nil
+	}
+	if ok {
+		return fallback, nil
+	}
+	if r.Prompt != nil {
+		return r.Prompt(question, echo)
+	}
+	return "", fmt.Errorf("no answer for keyboard-interactive prompt %q", question)
+}
+
+// isOTPQuestion 判断问题是否在询问一次性验证码
+func isOTPQuestion(question string) bool {
+	for _, word := range []string{"verification code", "one-time", "otp", "token", "authenticator", "code"} {
+		if strings.Contains(question, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadTOTPSecret 读取 base32 编码的 TOTP 密钥文件
+func LoadTOTPSecret(file string) ([]byte, error) {
+	buffer, err := ioutil.ReadFile(expandHome(file))
+	if err != nil {
+		return nil, err
+	}
+
+	secret := strings.ToUpper(strings.Join(strings.Fields(string(buffer)), ""))
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(secret, "="))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TOTP secret in %s: %v", file, err)
+	}
+	return key, nil
+}
+
+// TOTP 按 RFC 6238 生成 6 位验证码，步长 30 秒，HMAC-SHA1
+func TOTP(key []byte, t time.Time) string {
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(t.Unix()/30))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", code%1000000)
+}
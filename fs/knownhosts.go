@@ -0,0 +1,193 @@
+package fs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// 主机密钥校验策略
+const (
+	// HostKeyStrict 拒绝未知和已变更的主机密钥
+	HostKeyStrict = "strict"
+	// HostKeyAcceptNew 记录未知主机的密钥，拒绝已变更的主机密钥
+	HostKeyAcceptNew = "accept-new"
+	// HostKeyOff 不校验主机密钥
+	HostKeyOff = "off"
+)
+
+// hostKeyChecker 基于 known_hosts 文件和主机 CA 校验主机密钥
+type hostKeyChecker struct {
+	policy      string
+	files       []string
+	db          ssh.HostKeyCallback
+	authorities []ssh.PublicKey
+	sync.Mutex
+}
+
+// NewHostKeyCallback 根据校验策略和 known_hosts 文件创建主机密钥校验回调，
+// 支持哈希主机名以及 @revoked、@cert-authority 标记。
+// authorities 中的 CA 签发的主机证书对所有主机有效，无需 known_hosts 记录
+func NewHostKeyCallback(policy string, authorities []ssh.PublicKey, files ...string) (ssh.HostKeyCallback, error) {
+	switch policy {
+	case HostKeyOff:
+		logrus.Warn("host key verification is disabled")
+		return ssh.InsecureIgnoreHostKey(), nil
+	case HostKeyStrict, HostKeyAcceptNew:
+	default:
+		return nil, fmt.Errorf("unknown host key policy %q (one of %s, %s or %s)", policy, HostKeyStrict, HostKeyAcceptNew, HostKeyOff)
+	}
+
+	c := &hostKeyChecker{policy: policy, authorities: authorities}
+	for _, file := range files {
+		if file != "" {
+			c.files = append(c.files, expandHome(file))
+		}
+	}
+	if len(c.files) == 0 {
+		return nil, errors.New("no known_hosts file configured")
+	}
+
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c.check, nil
+}
+
+// load 重新读取 known_hosts 文件，不存在的文件视为空
+func (c *hostKeyChecker) load() error {
+	existing := []string{}
+	for _, file := range c.files {
+		_, err := os.Stat(file)
+		if err == nil {
+			existing = append(existing, file)
+			continue
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if len(existing) == 0 {
+		c.db = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			return &knownhosts.KeyError{}
+		}
+		return nil
+	}
+
+	db, err := knownhosts.New(existing...)
+	if err != nil {
+		return err
+	}
+	c.db = db
+	return nil
+}
+
+// check 实现 ssh.HostKeyCallback
+func (c *hostKeyChecker) check(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	c.Lock()
+	defer c.Unlock()
+
+	err := c.verify(hostname, remote, key)
+	if err == nil {
+		return nil
+	}
+
+	keyErr := &knownhosts.KeyError{}
+	if errors.As(err, &keyErr) && len(keyErr.Want) == 0 && c.policy == HostKeyAcceptNew {
+		return c.add(hostname, key)
+	}
+	return hostKeyError(hostname, key, err)
+}
+
+// verify 校验主机密钥，证书未被任何 CA 认可时与 OpenSSH 一样回退到证书内的公钥
+func (c *hostKeyChecker) verify(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	cert, ok := key.(*ssh.Certificate)
+	if ok && c.isAuthority(cert.SignatureKey, hostname) {
+		checker := &ssh.CertChecker{IsHostAuthority: c.isAuthority}
+		return checker.CheckHostKey(hostname, remote, key)
+	}
+
+	err := c.db(hostname, remote, key)
+	if err == nil || !ok {
+		return err
+	}
+
+	revoked := &knownhosts.RevokedError{}
+	if errors.As(err, &revoked) {
+		return err
+	}
+	return c.db(hostname, remote, cert.Key)
+}
+
+// isAuthority 判断 key 是否为配置的主机 CA
+func (c *hostKeyChecker) isAuthority(key ssh.PublicKey, address string) bool {
+	for _, authority := range c.authorities {
+		if bytes.Equal(authority.Marshal(), key.Marshal()) {
+			return true
+		}
+	}
+	return false
+}
+
+// add 将未知主机的密钥写入第一个 known_hosts 文件
+func (c *hostKeyChecker) add(hostname string, key ssh.PublicKey) error {
+	if cert, ok := key.(*ssh.Certificate); ok {
+		key = cert.Key
+	}
+
+	file := c.files[0]
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	line := knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key)
+	if _, err = fmt.Fprintln(f, line); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"host":        hostname,
+		"fingerprint": ssh.FingerprintSHA256(key),
+		"known_hosts": file,
+	}).Warn("permanently added host key")
+	return c.load()
+}
+
+// hostKeyError 生成包含主机密钥指纹的错误信息
+func hostKeyError(hostname string, key ssh.PublicKey, err error) error {
+	fingerprint := ssh.FingerprintSHA256(key)
+
+	keyErr := &knownhosts.KeyError{}
+	if errors.As(err, &keyErr) {
+		if len(keyErr.Want) == 0 {
+			return fmt.Errorf("host key verification failed: %s is not a known host (%s key %s)", hostname, key.Type(), fingerprint)
+		}
+		known := keyErr.Want[0]
+		return fmt.Errorf("host key verification failed: host key for %s has changed to %s key %s, known key is at %s:%d; possible man-in-the-middle attack",
+			hostname, key.Type(), fingerprint, known.Filename, known.Line)
+	}
+
+	revoked := &knownhosts.RevokedError{}
+	if errors.As(err, &revoked) {
+		return fmt.Errorf("host key verification failed: %s key %s for %s is revoked at %s:%d",
+			key.Type(), fingerprint, hostname, revoked.Revoked.Filename, revoked.Revoked.Line)
+	}
+
+	return fmt.Errorf("host key verification failed for %s (%s key %s): %v", hostname, key.Type(), fingerprint, err)
+}
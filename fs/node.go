@@ -1,6 +1,9 @@
 package fs
 
 import (
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"context"
 	"encoding/json"
 	kv "github.com/patrickmn/go-cache"
 	"github.com/pkg/sftp"
@@ -8,8 +11,11 @@ import (
 	sq "github.com/yireyun/go-queue"
 	"io"
 	"net/http"
+	"os"
 	"path/filepath"
 	"strconv"
+	"sync"
+	"time"
 )
 
 var ginode uint64 = 900000000
@@ -66,11 +72,17 @@ type Node struct {
 	path      string // 远程服务器的目录，如："/tmp/test"
 	localpath string // 本地绝对路径
 	isdir     bool
+	islink    bool
 	isroot    bool
+	changes   *uint64     // 通过挂载点修改文件内容的次数，同一 inode 的节点共享
+	appending *sync.Mutex // 串行化同一文件上的追加写，同一 inode 的节点共享
+	handles   *handleSet  // 已打开的文件句柄，同一 inode 的节点共享
+	xattrLock *sync.Mutex // 串行化扩展属性文件的读改写，每个名称有自己的扩展属性文件
 	parent    *Node
 	*File
 	*Dir
-	sftp *sftp.Client
+	*Symlink
+	sftp *Client
 }
 
 // MarshalJSON 自定义序列化
@@ -122,6 +134,9 @@ func (n *Node) MarshalJSON() ([]byte, error) {
 				}
 				return "dir"
 			}
+			if n.islink {
+				return "symlink"
+			}
 			return "file"
 		}(),
 		Files: func() []node {
@@ -162,6 +177,11 @@ func (n *Node) IsDir() bool {
 	return n.isdir
 }
 
+// IsLink 判断是否符号链接
+func (n *Node) IsLink() bool {
+	return n.islink
+}
+
 // IsRoot 判断是否根目录
 func (n *Node) IsRoot() bool {
 	return n.isroot
@@ -172,9 +192,9 @@ func (n *Node) GetInode() uint64 {
 	return n.inode
 }
 
-// Save 缓存 FsNode
+// Save 缓存 FsNode，硬链接共享 inode，inode 只对应最先保存的节点
 func (n *Node) Save() {
-	inodeCache.Set(strconv.FormatUint(n.inode, 10), n, kv.NoExpiration)
+	inodeCache.Add(strconv.FormatUint(n.inode, 10), n, kv.NoExpiration)
 	if n.parent != nil {
 		key := strconv.FormatUint(n.parent.inode, 10) + "_" + n.name
 		inodeCache.Set(key, n, kv.NoExpiration)
@@ -227,7 +247,7 @@ func reverse(s []string) []string {
 }
 
 // NewNode 新增节点
-func NewNode(sftp *sftp.Client, inode uint64, parent *Node, name string, isdir, isroot bool) *Node {
+func NewNode(sftp *Client, inode uint64, parent *Node, name string, isdir, isroot bool) *Node {
 	logrus.WithFields(map[string]interface{}{
 		"inode":  inode,
 		"name":   name,
@@ -236,29 +256,150 @@ func NewNode(sftp *sftp.Client, inode uint64, parent *Node, name string, isdir,
 	}).Debugln("NewNode...")
 	//debug.PrintStack()
 	node := &Node{
-		inode:  genInode(),
-		File:   &File{},
-		Dir:    &Dir{},
-		sftp:   sftp,
-		name:   name,
-		isdir:  isdir,
-		isroot: isroot,
-		parent: parent,
+		inode:     inode,
+		File:      &File{},
+		Dir:       &Dir{},
+		Symlink:   &Symlink{},
+		sftp:      sftp,
+		name:      name,
+		isdir:     isdir,
+		isroot:    isroot,
+		changes:   new(uint64),
+		appending: &sync.Mutex{},
+		handles:   &handleSet{handles: map[*File]struct{}{}},
+		xattrLock: &sync.Mutex{},
+		parent:    parent,
 	}
 	node.Dir.Node = node
 	node.File.Node = node
+	node.Symlink.Node = node
 	if isdir && isroot {
 		node.path = name
 		node.name = filepath.Base(name)
 	}
 
-	if inode > 0 {
-		node.inode = inode
+	if inode == 0 {
+		node.inode = genInode()
 	}
 	node.Save()
 	return node
 }
 
+// fsNode 按节点类型返回对应的 fs.Node
+func (n *Node) fsNode() fs.Node {
+	switch {
+	case n.isdir:
+		return n.Dir
+	case n.islink:
+		return n.Symlink
+	}
+	return n.File
+}
+
+// setattr 将 Setattr 请求中的大小、属主、权限和时间应用到远程文件，并返回刷新后的属性
+func (n *Node) setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) error {
+	p := n.Path()
+	n.invalidateAttr()
+	if req.Valid.Size() {
+		// 缓冲的写入在截断后提交会重新扩展文件
+		n.commitWrites(ctx)
+		err := n.sftp.Truncate(ctx, p, int64(req.Size))
+		n.resetReadAhead()
+		n.invalidateBlocks()
+		if err != nil {
+			return err
+		}
+	}
+
+	// 只修改 uid、gid 或其中一个时间时，另一个值沿用远程当前值
+	var stat *sftp.FileStat
+	if req.Valid.Uid() != req.Valid.Gid() || req.Valid.Atime() != req.Valid.Mtime() {
+		info, err := n.sftp.Lstat(ctx, p)
+		if err != nil {
+			return err
+		}
+		stat, _ = info.Sys().(*sftp.FileStat)
+		if stat == nil {
+			return fuse.EIO
+		}
+	}
+
+	if req.Valid.Uid() || req.Valid.Gid() {
+		uid, gid := req.Uid, req.Gid
+		if !req.Valid.Uid() {
+			uid = stat.UID
+		}
+		if !req.Valid.Gid() {
+			gid = stat.GID
+		}
+		if err := n.sftp.Chown(ctx, p, int(uid), int(gid)); err != nil {
+			return err
+		}
+	}
+
+	// chown 可能清除 setuid 位，因此在其后修改权限
+	if req.Valid.Mode() {
+		mode := req.Mode & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+		if err := n.sftp.Chmod(ctx, p, mode); err != nil {
+			return err
+		}
+	}
+
+	// 截断会更新 mtime，因此最后修改时间
+	if req.Valid.Atime() || req.Valid.Mtime() {
+		now := time.Now()
+		atime, mtime := req.Atime, req.Mtime
+		switch {
+		case req.Valid.AtimeNow():
+			atime = now
+		case !req.Valid.Atime():
+			atime = time.Unix(int64(stat.Atime), 0)
+		}
+		switch {
+		case req.Valid.MtimeNow():
+			mtime = now
+		case !req.Valid.Mtime():
+			mtime = time.Unix(int64(stat.Mtime), 0)
+		}
+		if err := n.sftp.Chtimes(ctx, p, atime, mtime); err != nil {
+			return err
+		}
+	}
+
+	return n.fsNode().Attr(ctx, &resp.Attr)
+}
+
+// handleSet 节点上已打开的文件句柄，Fsync 请求只带有节点，需要由此找到句柄
+type handleSet struct {
+	handles map[*File]struct{}
+	sync.Mutex
+}
+
+// add 记录打开的句柄
+func (s *handleSet) add(f *File) {
+	s.Lock()
+	defer s.Unlock()
+	s.handles[f] = struct{}{}
+}
+
+// remove 移除关闭的句柄
+func (s *handleSet) remove(f *File) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.handles, f)
+}
+
+// list 返回所有打开的句柄
+func (s *handleSet) list() []*File {
+	s.Lock()
+	defer s.Unlock()
+	handles := make([]*File, 0, len(s.handles))
+	for f := range s.handles {
+		handles = append(handles, f)
+	}
+	return handles
+}
+
 // GetNodeByID 根据 id 获取 Node 对象
 func GetNodeByID(inode uint64) (*Node, bool) {
 	c, ok := inodeCache.Get(strconv.FormatUint(uint64(inode), 10))
@@ -286,15 +427,30 @@ func (n *Node) GetChild(name string) (*Node, bool) {
 	return node.(*Node), true
 }
 
-// Remove 删除 Node
+// Remove 删除 Node，inode 对应的是该节点时释放 inode
 func (n *Node) Remove() {
-	inodeCache.Delete(strconv.FormatUint(n.inode, 10))
 	if n.parent != nil {
 		inodeCache.Delete(strconv.FormatUint(n.parent.inode, 10) + "_" + n.name)
 	}
+	key := strconv.FormatUint(n.inode, 10)
+	if node, ok := inodeCache.Get(key); !ok || node.(*Node) != n {
+		return
+	}
+	inodeCache.Delete(key)
 	n.rmInode()
 }
 
+// link 创建与 n 共享 inode 的新节点，作为通过挂载点创建的硬链接。
+// sftp 不提供链接数和 inode 号，服务器上已有的硬链接各自是独立的节点，链接数总是报告为 1
+func (n *Node) link(parent *Node, name string) *Node {
+	node := NewNode(n.sftp, n.inode, parent, name, false, false)
+	node.islink = n.islink
+	node.changes = n.changes
+	node.appending = n.appending
+	node.handles = n.handles
+	return node
+}
+
 // rmInode 释放inode
 func (n *Node) rmInode() {
 	_, _ = freeInode.Put(n.inode)
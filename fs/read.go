@@ -0,0 +1,93 @@
+package fs
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// blockSize 按块读取远程文件的块大小，也是预读和缓存的单位。
+// 每块由 sftp 拆分为多个 32 KiB 的请求并发发送
+const blockSize = 128 << 10
+
+// readBlock 读取第 index 块，优先使用预读的结果
+func (f *File) readBlock(ctx context.Context, index int64) ([]byte, error) {
+	if f.readahead != nil {
+		if p := f.readahead.lookup(index); p != nil {
+			select {
+			case <-p.done:
+				if p.err == nil {
+					return p.data, nil
+				}
+			case <-ctx.Done():
+				return nil, contextError(ctx.Err())
+			}
+		}
+	}
+	return f.fetchBlock(ctx, index)
+}
+
+// fetchBlock 从磁盘缓存或服务器读取第 index 块，文件末尾的块可能不足 blockSize
+func (f *File) fetchBlock(ctx context.Context, index int64) ([]byte, error) {
+	cache := f.sftp.cache
+	key := ""
+	if cache != nil && f.cached() {
+		key = blockKey(f.version, index)
+		if block, ok := cache.get(f.Path(), key); ok {
+			return block, nil
+		}
+	}
+
+	block := make([]byte, blockSize)
+	n, err := f.file.ReadAt(ctx, block, index*blockSize)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	// 读取期间文件被修改时，读到的数据可能不属于该版本
+	if key != "" && f.cached() {
+		cache.put(f.Path(), key, block[:n])
+	}
+	return block[:n], nil
+}
+
+// readAt 并发读取 [off, off+len(b)) 覆盖的所有块，返回文件末尾前实际读到的字节数
+func (f *File) readAt(ctx context.Context, b []byte, off int64) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	if f.readahead != nil {
+		f.readahead.observe(off, len(b))
+	}
+
+	first := off / blockSize
+	last := (off + int64(len(b)) - 1) / blockSize
+	blocks := make([][]byte, last-first+1)
+	errs := make([]error, len(blocks))
+
+	var wg sync.WaitGroup
+	for i := range blocks {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			blocks[i], errs[i] = f.readBlock(ctx, first+int64(i))
+		}(i)
+	}
+	wg.Wait()
+
+	n := 0
+	for i, block := range blocks {
+		if errs[i] != nil {
+			return n, errs[i]
+		}
+		start := off + int64(n) - (first+int64(i))*blockSize
+		if start >= int64(len(block)) {
+			break
+		}
+		n += copy(b[n:], block[start:])
+		if len(block) < blockSize {
+			break
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,150 @@
+package fs
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+)
+
+// readAheadMemory 所有句柄预读数据占用的内存
+var readAheadMemory int64
+
+// prefetch 后台读取中的块
+type prefetch struct {
+	done   chan struct{}
+	data   []byte
+	err    error
+	cancel context.CancelFunc
+}
+
+// readAhead 句柄的顺序预读：读取落在当前读取位置前后一个窗口内时视为顺序读取，在后台读取之后 window 个块，
+// 跳到窗口之外时丢弃已预读的块。内核的异步读请求可能乱序到达，因此不要求紧接上一次读取的末尾。
+// 所有句柄的预读数据总量不超过 maxMemory
+type readAhead struct {
+	file      *File
+	window    int64
+	maxMemory int64
+	next      int64 // 已读取的最远位置，-1 表示尚未读取
+	blocks    map[int64]*prefetch
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+	sync.Mutex
+}
+
+// newReadAhead 创建预读，window 和 maxMemory 以字节为单位，window 不足一块时不预读
+func newReadAhead(f *File, window, maxMemory int64) *readAhead {
+	if window < blockSize {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &readAhead{
+		file:      f,
+		window:    window / blockSize,
+		maxMemory: maxMemory,
+		next:      -1,
+		blocks:    map[int64]*prefetch{},
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+}
+
+// observe 记录一次 [off, off+size) 的读取，顺序读取时预读其后的块
+func (r *readAhead) observe(off int64, size int) {
+	r.Lock()
+	defer r.Unlock()
+
+	span := r.window * blockSize
+	sequential := r.next >= 0 && off >= r.next-span && off <= r.next+span
+	if !sequential {
+		if len(r.blocks) > 0 {
+			logrus.WithField("path", r.file.Path()).Debug("random read, dropping read-ahead window")
+		}
+		r.drop()
+		r.next = off + int64(size)
+		return
+	}
+	if end := off + int64(size); end > r.next {
+		r.next = end
+	}
+
+	// 落后读取位置一个窗口以上的块不会再被用到，更近的块可能还有乱序到达的请求在读
+	for index := range r.blocks {
+		if index < (r.next-span)/blockSize {
+			r.release(index)
+		}
+	}
+
+	last := (r.next - 1) / blockSize
+	for index := last + 1; index <= last+r.window; index++ {
+		if _, ok := r.blocks[index]; ok {
+			continue
+		}
+		if atomic.AddInt64(&readAheadMemory, blockSize) > r.maxMemory {
+			atomic.AddInt64(&readAheadMemory, -blockSize)
+			return
+		}
+		r.start(index)
+	}
+}
+
+// start 在后台读取第 index 块
+func (r *readAhead) start(index int64) {
+	ctx, cancel := context.WithCancel(r.ctx)
+	p := &prefetch{done: make(chan struct{}), cancel: cancel}
+	r.blocks[index] = p
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		p.data, p.err = r.file.fetchBlock(ctx, index)
+		close(p.done)
+	}()
+}
+
+// lookup 返回第 index 块的预读结果，没有预读时返回 nil。
+// 块在读取位置越过它之前一直保留，以便同一块内的多次小读取共用
+func (r *readAhead) lookup(index int64) *prefetch {
+	r.Lock()
+	defer r.Unlock()
+	return r.blocks[index]
+}
+
+// release 丢弃第 index 块，调用时需持有锁
+func (r *readAhead) release(index int64) {
+	r.blocks[index].cancel()
+	delete(r.blocks, index)
+	atomic.AddInt64(&readAheadMemory, -blockSize)
+}
+
+// drop 丢弃所有预读的块，调用时需持有锁
+func (r *readAhead) drop() {
+	for index := range r.blocks {
+		r.release(index)
+	}
+}
+
+// reset 丢弃所有预读的块，写入后预读的数据可能已过期
+func (r *readAhead) reset() {
+	r.Lock()
+	defer r.Unlock()
+	r.drop()
+	r.next = -1
+}
+
+// resetReadAhead 丢弃节点所有句柄预读的块，在通过挂载点修改文件后调用
+func (n *Node) resetReadAhead() {
+	for _, f := range n.handles.list() {
+		if f.readahead != nil {
+			f.readahead.reset()
+		}
+	}
+}
+
+// stop 取消并等待所有后台读取，在关闭句柄前调用
+func (r *readAhead) stop() {
+	r.cancel()
+	r.wg.Wait()
+	r.reset()
+}
@@ -15,20 +15,211 @@
 package fs
 
 import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/ssh"
 	"io/ioutil"
+	"os"
+	"sync"
 )
 
+// PassphraseFunc 返回加密私钥 file 的口令
+type PassphraseFunc func(file string) ([]byte, error)
+
 // PublicKeyFile ssh
-func PublicKeyFile(file string) (ssh.AuthMethod, error) {
+func PublicKeyFile(file string, passphrase PassphraseFunc) (ssh.AuthMethod, error) {
+	key, err := PrivateKeySigner(file, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.PublicKeys(key), nil
+}
+
+// PrivateKeySigner 读取私钥文件，私钥加密时通过 passphrase 获取口令解密，
+// 支持 OpenSSH 和 PEM 格式
+func PrivateKeySigner(file string, passphrase PassphraseFunc) (ssh.Signer, error) {
 	buffer, err := ioutil.ReadFile(file)
 	if err != nil {
 		return nil, err
 	}
 
 	key, err := ssh.ParsePrivateKey(buffer)
+	missing := &ssh.PassphraseMissingError{}
+	if !errors.As(err, &missing) {
+		return key, err
+	}
+
+	if passphrase == nil {
+		return nil, fmt.Errorf("private key %s is encrypted and no passphrase was given", file)
+	}
+	pass, err := passphrase(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not read passphrase for %s: %v", file, err)
+	}
+
+	key, err = ssh.ParsePrivateKeyWithPassphrase(buffer, pass)
+	if err == x509.IncorrectPasswordError {
+		return nil, fmt.Errorf("incorrect passphrase for private key %s", file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt private key %s: %v", file, err)
+	}
+	return key, nil
+}
+
+// keyFiles 私钥文件中的身份，认证时才读取，加密私钥到那时才询问口令
+type keyFiles struct {
+	files      []string
+	passphrase PassphraseFunc
+	keys       map[string]ssh.Signer // 已读取的私钥，重连时不再询问口令
+	failed     map[string]bool       // 无法读取或解密的私钥，不再重试
+	sync.Mutex
+}
+
+// newKeyFiles 创建私钥文件的身份来源
+func newKeyFiles(files []string, passphrase PassphraseFunc) *keyFiles {
+	return &keyFiles{
+		files:      files,
+		passphrase: passphrase,
+		keys:       map[string]ssh.Signer{},
+		failed:     map[string]bool{},
+	}
+}
+
+// Signers 返回私钥文件中的身份。公钥已在 loaded 中（如 ssh-agent 已加载）的加密私钥不再解密，
+// 不存在的私钥被忽略，无法读取或解密的私钥记录警告后跳过
+func (k *keyFiles) Signers(loaded []ssh.Signer) ([]ssh.Signer, error) {
+	k.Lock()
+	defer k.Unlock()
+
+	signers := []ssh.Signer{}
+	for _, file := range k.files {
+		if key, ok := k.keys[file]; ok {
+			signers = append(signers, key)
+			continue
+		}
+		if k.failed[file] {
+			continue
+		}
+		if pub := encryptedPublicKey(file); pub != nil && hasPublicKey(loaded, pub) {
+			logrus.WithField("key", file).Debug("encrypted private key is already offered by ssh-agent")
+			continue
+		}
+
+		key, err := PrivateKeySigner(file, k.passphrase)
+		if os.IsNotExist(err) {
+			logrus.WithError(err).Debug("skipping missing private key")
+			continue
+		}
+		if err != nil {
+			logrus.WithError(err).Warn("skipping private key")
+			k.failed[file] = true
+			continue
+		}
+		k.keys[file] = key
+		signers = append(signers, key)
+	}
+	return signers, nil
+}
+
+// encryptedPublicKey 不解密地返回加密私钥 file 的公钥：OpenSSH 格式的私钥中带有公钥，
+// 其他格式读取同名的 .pub 文件。私钥未加密或得不到公钥时返回 nil
+func encryptedPublicKey(file string) ssh.PublicKey {
+	buffer, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil
+	}
+	_, err = ssh.ParsePrivateKey(buffer)
+	missing := &ssh.PassphraseMissingError{}
+	if !errors.As(err, &missing) {
+		return nil
+	}
+	if missing.PublicKey != nil {
+		return missing.PublicKey
+	}
+
+	buffer, err = ioutil.ReadFile(file + ".pub")
+	if err != nil {
+		return nil
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(buffer)
+	if err != nil {
+		return nil
+	}
+	return pub
+}
+
+// hasPublicKey 判断 signers 中是否有公钥为 pub 的身份
+func hasPublicKey(signers []ssh.Signer, pub ssh.PublicKey) bool {
+	for _, signer := range signers {
+		if bytes.Equal(signer.PublicKey().Marshal(), pub.Marshal()) {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadCertificate 读取 OpenSSH 证书文件，如 id_ed25519-cert.pub
+func LoadCertificate(file string) (*ssh.Certificate, error) {
+	buffer, err := ioutil.ReadFile(file)
 	if err != nil {
 		return nil, err
 	}
-	return ssh.PublicKeys(key), nil
+
+	key, _, _, _, err := ssh.ParseAuthorizedKey(buffer)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse certificate %s: %v", file, err)
+	}
+	cert, ok := key.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an ssh certificate", file)
+	}
+	return cert, nil
+}
+
+// LoadAuthorities 读取 CA 公钥文件，每行一个 authorized_keys 格式的公钥
+func LoadAuthorities(files ...string) ([]ssh.PublicKey, error) {
+	keys := []ssh.PublicKey{}
+	for _, file := range files {
+		buffer, err := ioutil.ReadFile(expandHome(file))
+		if err != nil {
+			return nil, err
+		}
+		for len(bytes.TrimSpace(buffer)) > 0 {
+			key, _, _, rest, err := ssh.ParseAuthorizedKey(buffer)
+			if err != nil {
+				return nil, fmt.Errorf("could not parse CA key in %s: %v", file, err)
+			}
+			keys = append(keys, key)
+			buffer = rest
+		}
+	}
+	return keys, nil
+}
+
+// withCertificates 在与证书公钥匹配的身份前插入证书身份
+func withCertificates(signers []ssh.Signer, certs []*ssh.Certificate) []ssh.Signer {
+	if len(certs) == 0 {
+		return signers
+	}
+
+	all := []ssh.Signer{}
+	for _, signer := range signers {
+		for _, cert := range certs {
+			if !bytes.Equal(cert.Key.Marshal(), signer.PublicKey().Marshal()) {
+				continue
+			}
+			certSigner, err := ssh.NewCertSigner(cert, signer)
+			if err != nil {
+				logrus.WithError(err).Warn("could not use ssh certificate")
+				continue
+			}
+			all = append(all, certSigner)
+		}
+		all = append(all, signer)
+	}
+	return all
 }
@@ -0,0 +1,82 @@
+package fs
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// writeEncryptedKey 生成以 passphrase 加密的 ed25519 私钥文件，返回文件路径和对应的身份
+func writeEncryptedKey(t *testing.T, passphrase string) (string, ssh.Signer) {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte(passphrase))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := ioutil.WriteFile(file, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file, signer
+}
+
+// TestAuthMethodsDefersPassphrase 组装认证方式时不询问口令，没有口令的加密私钥不导致失败
+func TestAuthMethodsDefersPassphrase(t *testing.T) {
+	file, _ := writeEncryptedKey(t, "secret")
+	_, err := authMethods(ClientOptions{
+		PrivateKeys: []string{file},
+		Passphrase: func(string) ([]byte, error) {
+			t.Fatal("passphrase requested while building the auth methods")
+			return nil, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := newKeyFiles([]string{file}, nil)
+	signers, err := keys.Signers(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signers) != 0 {
+		t.Fatalf("got %d signers for a key without passphrase, want 0", len(signers))
+	}
+}
+
+// TestKeyFilesSkipsAgentKeys ssh-agent 已提供的加密私钥不再询问口令，其他私钥只询问一次
+func TestKeyFilesSkipsAgentKeys(t *testing.T) {
+	agentFile, agentKey := writeEncryptedKey(t, "agent")
+	file, _ := writeEncryptedKey(t, "secret")
+
+	asked := map[string]int{}
+	keys := newKeyFiles([]string{agentFile, file}, func(f string) ([]byte, error) {
+		asked[f]++
+		return []byte("secret"), nil
+	})
+	for i := 0; i < 2; i++ {
+		signers, err := keys.Signers([]ssh.Signer{agentKey})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(signers) != 1 {
+			t.Fatalf("got %d signers, want 1", len(signers))
+		}
+	}
+	if asked[agentFile] != 0 || asked[file] != 1 {
+		t.Fatalf("passphrase requests: %v", asked)
+	}
+}
@@ -0,0 +1,337 @@
+package fs
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// HostConfig OpenSSH 客户端配置中解析出的主机配置
+type HostConfig struct {
+	// Alias 命令行上给出的主机名或别名
+	Alias               string
+	HostName            string
+	Port                string
+	User                string
+	IdentityFiles       []string
+	IdentitiesOnly      bool
+	ProxyJump           string
+	ServerAliveInterval time.Duration
+	ServerAliveCountMax int
+}
+
+// Addr 返回 host:port 形式的连接地址
+func (h *HostConfig) Addr() string {
+	return net.JoinHostPort(h.HostName, h.Port)
+}
+
+// SSHConfig OpenSSH 客户端配置文件，支持 Host、Match 和 Include
+type SSHConfig struct {
+	blocks []*sshConfigBlock
+}
+
+// sshConfigBlock Host 或 Match 块，第一个 Host 之前的配置属于匹配所有主机的块
+type sshConfigBlock struct {
+	match    bool
+	patterns []string
+	criteria [][2]string
+	options  [][2]string
+}
+
+// LoadSSHConfig 按顺序读取配置文件，不存在的文件被忽略
+func LoadSSHConfig(files ...string) (*SSHConfig, error) {
+	c := &SSHConfig{}
+	for _, file := range files {
+		if file == "" {
+			continue
+		}
+		if err := c.read(expandHome(file), 0); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// read 解析单个配置文件，Include 的文件按原位置展开
+func (c *SSHConfig) read(file string, depth int) error {
+	if depth > 16 {
+		return fmt.Errorf("%s: too many nested includes", file)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if depth == 0 {
+		c.blocks = append(c.blocks, &sshConfigBlock{patterns: []string{"*"}})
+	}
+
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		key, args, err := splitConfigLine(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", file, lineNum, err)
+		}
+		if key == "" {
+			continue
+		}
+		if len(args) == 0 {
+			return fmt.Errorf("%s:%d: missing argument for %s", file, lineNum, key)
+		}
+
+		switch key {
+		case "host":
+			c.blocks = append(c.blocks, &sshConfigBlock{patterns: args})
+		case "match":
+			criteria, err := parseMatch(args)
+			if err != nil {
+				return fmt.Errorf("%s:%d: %v", file, lineNum, err)
+			}
+			c.blocks = append(c.blocks, &sshConfigBlock{match: true, criteria: criteria})
+		case "include":
+			for _, pattern := range args {
+				pattern = expandHome(pattern)
+				if !filepath.IsAbs(pattern) {
+					pattern = filepath.Join(os.Getenv("HOME"), ".ssh", pattern)
+				}
+				matches, err := filepath.Glob(pattern)
+				if err != nil {
+					return fmt.Errorf("%s:%d: %v", file, lineNum, err)
+				}
+				for _, match := range matches {
+					if err := c.read(match, depth+1); err != nil {
+						return err
+					}
+				}
+			}
+		default:
+			block := c.blocks[len(c.blocks)-1]
+			block.options = append(block.options, [2]string{key, strings.Join(args, " ")})
+		}
+	}
+	return scanner.Err()
+}
+
+// splitConfigLine 拆分 "Key value" 或 "Key=value" 格式的配置行，支持双引号
+func splitConfigLine(line string) (string, []string, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || line[0] == '#' {
+		return "", nil, nil
+	}
+
+	end := strings.IndexAny(line, " \t=")
+	if end < 0 {
+		return strings.ToLower(line), nil, nil
+	}
+	key := strings.ToLower(line[:end])
+	rest := strings.TrimLeft(line[end:], " \t")
+	if strings.HasPrefix(rest, "=") {
+		rest = strings.TrimLeft(rest[1:], " \t")
+	}
+
+	args := []string{}
+	for rest != "" {
+		if rest[0] == '#' {
+			break
+		}
+		if rest[0] == '"' {
+			i := strings.IndexByte(rest[1:], '"')
+			if i < 0 {
+				return "", nil, fmt.Errorf("unterminated quote in %s", key)
+			}
+			args = append(args, rest[1:i+1])
+			rest = strings.TrimLeft(rest[i+2:], " \t")
+			continue
+		}
+		i := strings.IndexAny(rest, " \t")
+		if i < 0 {
+			i = len(rest)
+		}
+		args = append(args, rest[:i])
+		rest = strings.TrimLeft(rest[i:], " \t")
+	}
+	return key, args, nil
+}
+
+// parseMatch 解析 Match 条件
+func parseMatch(args []string) ([][2]string, error) {
+	criteria := [][2]string{}
+	for i := 0; i < len(args); i++ {
+		name := strings.ToLower(args[i])
+		switch strings.TrimPrefix(name, "!") {
+		case "all", "canonical", "final":
+			criteria = append(criteria, [2]string{name, ""})
+		case "host", "originalhost", "user", "localuser", "exec":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("missing argument for Match %s", name)
+			}
+			i++
+			criteria = append(criteria, [2]string{name, args[i]})
+		default:
+			return nil, fmt.Errorf("unsupported Match criteria %q", name)
+		}
+	}
+	return criteria, nil
+}
+
+// Resolve 按照 ssh 的规则解析主机别名，每个选项取第一次出现的值，IdentityFile 累加
+func (c *SSHConfig) Resolve(alias string) *HostConfig {
+	values := map[string]string{}
+	identityFiles := []string{}
+	localUser := currentUser()
+
+	for _, block := range c.blocks {
+		if block.match {
+			hostname := alias
+			if v, ok := values["hostname"]; ok {
+				hostname = strings.Replace(v, "%h", alias, -1)
+			}
+			ruser := values["user"]
+			if ruser == "" {
+				ruser = localUser
+			}
+			if !block.matches(alias, hostname, ruser, localUser) {
+				continue
+			}
+		} else if !matchHostPatterns(block.patterns, alias) {
+			continue
+		}
+
+		for _, option := range block.options {
+			if option[0] == "identityfile" {
+				identityFiles = append(identityFiles, option[1])
+				continue
+			}
+			if _, ok := values[option[0]]; !ok {
+				values[option[0]] = option[1]
+			}
+		}
+	}
+
+	// 与 ssh 一样，配置中没有 User 时使用本地用户名
+	h := &HostConfig{
+		Alias:               alias,
+		HostName:            alias,
+		Port:                "22",
+		User:                localUser,
+		ServerAliveCountMax: 3,
+	}
+	if v, ok := values["user"]; ok {
+		h.User = v
+	}
+	if v, ok := values["hostname"]; ok {
+		h.HostName = strings.Replace(v, "%h", alias, -1)
+	}
+	if v, ok := values["port"]; ok {
+		h.Port = v
+	}
+	if v, ok := values["proxyjump"]; ok && strings.ToLower(v) != "none" {
+		h.ProxyJump = v
+	}
+	if v, ok := values["identitiesonly"]; ok {
+		h.IdentitiesOnly = strings.ToLower(v) == "yes"
+	}
+	if v, ok := values["serveraliveinterval"]; ok {
+		if seconds, err := strconv.Atoi(v); err == nil {
+			h.ServerAliveInterval = time.Duration(seconds) * time.Second
+		} else {
+			logrus.WithField("value", v).Warn("invalid ServerAliveInterval in ssh config")
+		}
+	}
+	if v, ok := values["serveralivecountmax"]; ok {
+		if count, err := strconv.Atoi(v); err == nil {
+			h.ServerAliveCountMax = count
+		} else {
+			logrus.WithField("value", v).Warn("invalid ServerAliveCountMax in ssh config")
+		}
+	}
+
+	for _, file := range identityFiles {
+		if strings.ToLower(file) == "none" {
+			continue
+		}
+		file = strings.NewReplacer(
+			"%%", "%",
+			"%d", os.Getenv("HOME"),
+			"%u", localUser,
+			"%h", h.HostName,
+			"%p", h.Port,
+			"%r", h.User,
+		).Replace(file)
+		h.IdentityFiles = append(h.IdentityFiles, expandHome(file))
+	}
+	return h
+}
+
+// matches 判断 Match 块是否匹配，所有条件都满足时匹配
+func (b *sshConfigBlock) matches(alias, hostname, ruser, luser string) bool {
+	for _, criterion := range b.criteria {
+		name := criterion[0]
+		negate := strings.HasPrefix(name, "!")
+		var ok bool
+		switch strings.TrimPrefix(name, "!") {
+		case "all", "final":
+			ok = true
+		case "canonical":
+			ok = false
+		case "host":
+			ok = matchPatternList(criterion[1], hostname)
+		case "originalhost":
+			ok = matchPatternList(criterion[1], alias)
+		case "user":
+			ok = matchPatternList(criterion[1], ruser)
+		case "localuser":
+			ok = matchPatternList(criterion[1], luser)
+		case "exec":
+			logrus.WithField("command", criterion[1]).Warn("Match exec is not supported in ssh config, ignoring block")
+			ok = false
+		}
+		if ok == negate {
+			return false
+		}
+	}
+	return true
+}
+
+// matchHostPatterns 匹配 Host 行的模式，任意模式匹配且没有否定模式匹配时成立
+func matchHostPatterns(patterns []string, host string) bool {
+	matched := false
+	for _, pattern := range patterns {
+		if strings.HasPrefix(pattern, "!") {
+			if ok, _ := path.Match(strings.ToLower(pattern[1:]), strings.ToLower(host)); ok {
+				return false
+			}
+			continue
+		}
+		if ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(host)); ok {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// matchPatternList 匹配 Match 条件中以逗号分隔的模式列表
+func matchPatternList(list, value string) bool {
+	return matchHostPatterns(strings.Split(list, ","), value)
+}
+
+// currentUser 返回本地用户名
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
@@ -0,0 +1,47 @@
+package fs
+
+import (
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"context"
+	"github.com/pkg/sftp"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+// Symlink 符号链接节点
+type Symlink struct {
+	*Node
+}
+
+var _ fs.Node = (*Symlink)(nil)
+
+// Attr Symlink，获取链接本身的属性
+func (l *Symlink) Attr(ctx context.Context, a *fuse.Attr) error {
+	logrus.WithField("path", l.Path()).Debug("handling Symlink.Attr call")
+	stat, err := l.stat(ctx)
+	if err != nil {
+		return err
+	}
+
+	statT, ok := stat.Sys().(*sftp.FileStat)
+	if ok {
+		a.Atime = time.Unix(int64(statT.Atime), 0)
+	}
+
+	a.Valid = l.sftp.opts.AttrTimeout
+	a.Inode = l.GetInode()
+	a.Mode = stat.Mode()
+	a.Size = uint64(stat.Size())
+	a.Ctime = stat.ModTime()
+	a.Mtime = stat.ModTime()
+	return nil
+}
+
+var _ fs.NodeReadlinker = (*Symlink)(nil)
+
+// Readlink Symlink
+func (l *Symlink) Readlink(ctx context.Context, req *fuse.ReadlinkRequest) (string, error) {
+	logrus.WithField("path", l.Path()).Debug("handling Symlink.Readlink call")
+	return l.sftp.ReadLink(ctx, l.Path())
+}
@@ -15,32 +15,166 @@
 package fs
 
 import (
+	"fmt"
+	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/ssh"
-	"log"
-	"net"
+	"os"
+	"path/filepath"
+	"strings"
 )
 
+// 认证方式，用于 ClientOptions.AuthOrder
+const (
+	// AuthAgent 使用 ssh-agent 中的全部身份
+	AuthAgent = "agent"
+	// AuthKey 使用私钥文件
+	AuthKey = "key"
+	// AuthKeyboardInteractive 使用 keyboard-interactive 回答服务器的问题
+	AuthKeyboardInteractive = "keyboard-interactive"
+	// AuthPassword 使用密码
+	AuthPassword = "password"
+)
+
+// DefaultAuthOrder 默认认证顺序
+var DefaultAuthOrder = []string{AuthAgent, AuthKey, AuthKeyboardInteractive, AuthPassword}
+
+// ClientOptions ssh 客户端配置项
+type ClientOptions struct {
+	User     string
+	Password string
+	// PrivateKeys 私钥文件列表，不存在的文件被忽略
+	PrivateKeys []string
+	// IdentitiesOnly 只使用私钥文件，不使用 ssh-agent
+	IdentitiesOnly bool
+	// Certificates 证书文件，与私钥同名的 -cert.pub 文件会被自动加载
+	Certificates []string
+	// Passphrase 获取加密私钥的口令，为空时不支持加密私钥
+	Passphrase PassphraseFunc
+	// Responder 回答 keyboard-interactive 认证的问题，为空时不使用该认证方式
+	Responder *Responder
+	// AuthOrder 认证方式的尝试顺序，为空时使用 DefaultAuthOrder
+	AuthOrder []string
+	// KnownHosts known_hosts 文件列表
+	KnownHosts []string
+	// HostKeyPolicy 主机密钥校验策略
+	HostKeyPolicy string
+	// HostCAs 签发主机证书的 CA 公钥文件
+	HostCAs []string
+}
+
 // NewConfig creates a new config
-func NewConfig(user, password string, privateKeyPath string) *ssh.ClientConfig {
-	auth := []ssh.AuthMethod{
-		ssh.Password(password),
+func NewConfig(opts ClientOptions) (*ssh.ClientConfig, error) {
+	authorities, err := LoadAuthorities(opts.HostCAs...)
+	if err != nil {
+		return nil, err
+	}
+	hostKeyCallback, err := NewHostKeyCallback(opts.HostKeyPolicy, authorities, opts.KnownHosts...)
+	if err != nil {
+		return nil, err
 	}
 
-	publicKey, err := PublicKeyFile(privateKeyPath)
-	if err == nil {
-		auth = append(auth, publicKey)
-	} else {
-		log.Println(err)
+	auth, err := authMethods(opts)
+	if err != nil {
+		return nil, err
 	}
 
 	return &ssh.ClientConfig{
-		User: user,
+		User: opts.User,
 		Auth: auth,
 		Config: ssh.Config{
 			Ciphers: []string{"aes128-ctr", "aes192-ctr", "aes256-ctr", "aes128-gcm@openssh.com", "arcfour256", "arcfour128", "aes128-cbc", "3des-cbc", "aes192-cbc", "aes256-cbc"},
 		},
-		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
-			return nil
-		},
+		HostKeyCallback: hostKeyCallback,
+	}, nil
+}
+
+// authMethods 按 AuthOrder 组装认证方式。
+// ssh 客户端对同一种认证方式只尝试一次，所以 agent 和私钥文件的身份
+// 按顺序合并到同一个 publickey 认证方式中，有证书的身份先以证书尝试。
+// 身份在认证时才收集，无法使用的私钥不影响其他认证方式
+func authMethods(opts ClientOptions) ([]ssh.AuthMethod, error) {
+	order := opts.AuthOrder
+	if len(order) == 0 {
+		order = DefaultAuthOrder
+	}
+
+	certs := []*ssh.Certificate{}
+	for _, file := range opts.Certificates {
+		cert, err := LoadCertificate(expandHome(file))
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+
+	auth := []ssh.AuthMethod{}
+	signers := []func(loaded []ssh.Signer) ([]ssh.Signer, error){}
+	publicKeys := false
+	for _, method := range order {
+		switch method {
+		case AuthAgent:
+			if opts.IdentitiesOnly {
+				continue
+			}
+			keyring := newAgentKeyring(os.Getenv("SSH_AUTH_SOCK"))
+			signers = append(signers, func([]ssh.Signer) ([]ssh.Signer, error) {
+				return keyring.Signers()
+			})
+		case AuthKey:
+			// 私钥在认证时才读取和解密，证书不需要口令，提前读取
+			for _, file := range opts.PrivateKeys {
+				cert, err := LoadCertificate(file + "-cert.pub")
+				if os.IsNotExist(err) {
+					continue
+				}
+				if err != nil {
+					return nil, err
+				}
+				certs = append(certs, cert)
+			}
+			signers = append(signers, newKeyFiles(opts.PrivateKeys, opts.Passphrase).Signers)
+		case AuthKeyboardInteractive:
+			if opts.Responder.enabled() {
+				auth = append(auth, ssh.KeyboardInteractive(opts.Responder.Challenge))
+			}
+			continue
+		case AuthPassword:
+			if opts.Password != "" {
+				auth = append(auth, ssh.Password(opts.Password))
+			}
+			continue
+		default:
+			return nil, fmt.Errorf("unknown auth method %q (one of %s, %s, %s or %s)", method, AuthAgent, AuthKey, AuthKeyboardInteractive, AuthPassword)
+		}
+
+		if !publicKeys {
+			publicKeys = true
+			auth = append(auth, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+				return withCertificates(collectSigners(signers), certs), nil
+			}))
+		}
+	}
+	return auth, nil
+}
+
+// collectSigners 依次收集各来源的身份，每个来源可以看到之前来源的身份，单个来源失败不影响其他来源
+func collectSigners(sources []func(loaded []ssh.Signer) ([]ssh.Signer, error)) []ssh.Signer {
+	all := []ssh.Signer{}
+	for _, source := range sources {
+		signers, err := source(all)
+		if err != nil {
+			logrus.WithError(err).Debug("skipping ssh identities")
+			continue
+		}
+		all = append(all, signers...)
+	}
+	return all
+}
+
+// expandHome 展开路径开头的 ~
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		return filepath.Join(os.Getenv("HOME"), path[1:])
 	}
+	return path
 }
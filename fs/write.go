@@ -0,0 +1,125 @@
+package fs
+
+import (
+	"context"
+	"sync"
+)
+
+// writeBuffer 句柄的写缓冲，合并连续的小块写入，缓冲区满、写入位置不连续、Flush 或关闭时
+// 一次写入服务器。写入失败的数据被丢弃，错误保留到下一次 Flush 返回
+type writeBuffer struct {
+	data []byte
+	off  int64 // data[0] 在文件中的位置
+	size int   // 缓冲区满时写入服务器
+	err  error
+	sync.Mutex
+}
+
+// BufferAt 将 off 处的写入放入缓冲区，未启用写缓冲时直接写入服务器
+func (h *Handle) BufferAt(ctx context.Context, b []byte, off int64) (int, error) {
+	w := h.buffer
+	if w == nil {
+		return h.WriteAt(ctx, b, off)
+	}
+
+	w.Lock()
+	defer w.Unlock()
+	if w.err != nil {
+		return 0, w.err
+	}
+	if len(w.data) > 0 && off != w.off+int64(len(w.data)) {
+		if err := h.flush(ctx); err != nil {
+			return 0, err
+		}
+	}
+
+	if len(w.data) == 0 {
+		w.off = off
+	}
+	w.data = append(w.data, b...)
+	if len(w.data) >= w.size {
+		if err := h.flush(ctx); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// flush 将缓冲数据写入服务器，调用时需持有缓冲区的锁。
+// WriteAt 等待写入完成后才返回，成功后缓冲区可以复用
+func (h *Handle) flush(ctx context.Context) error {
+	w := h.buffer
+	if len(w.data) == 0 {
+		return nil
+	}
+
+	_, err := h.WriteAt(ctx, w.data, w.off)
+	if err != nil {
+		// 失败的写入可能已部分发出，不复用其缓冲区
+		w.data = nil
+		w.err = err
+		return err
+	}
+	w.data = w.data[:0]
+	return nil
+}
+
+// Flush 将缓冲数据写入服务器，返回此前延迟写入时发生的错误
+func (h *Handle) Flush(ctx context.Context) error {
+	w := h.buffer
+	if w == nil {
+		return nil
+	}
+
+	w.Lock()
+	defer w.Unlock()
+	h.flush(ctx)
+	err := w.err
+	w.err = nil
+	return err
+}
+
+// bufferedEnd 返回缓冲数据末尾在文件中的位置，没有缓冲数据时返回 0
+func (h *Handle) bufferedEnd() int64 {
+	w := h.buffer
+	if w == nil {
+		return 0
+	}
+
+	w.Lock()
+	defer w.Unlock()
+	if len(w.data) == 0 {
+		return 0
+	}
+	return w.off + int64(len(w.data))
+}
+
+// commit 将缓冲数据写入服务器，错误保留到 Flush 返回
+func (h *Handle) commit(ctx context.Context) {
+	w := h.buffer
+	if w == nil {
+		return
+	}
+
+	w.Lock()
+	defer w.Unlock()
+	h.flush(ctx)
+}
+
+// commitWrites 将节点所有句柄缓冲的数据写入服务器，在读取和截断前调用
+func (n *Node) commitWrites(ctx context.Context) {
+	for _, f := range n.handles.list() {
+		f.file.commit(ctx)
+	}
+}
+
+// bufferedSize 返回节点所有句柄缓冲的数据写入后文件的最小大小
+func (n *Node) bufferedSize() int64 {
+	size := int64(0)
+	for _, f := range n.handles.list() {
+		if end := f.file.bufferedEnd(); end > size {
+			size = end
+		}
+	}
+	return size
+}
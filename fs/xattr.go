@@ -0,0 +1,186 @@
+package fs
+
+import (
+	"bazil.org/fuse"
+	"context"
+	"encoding/json"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// 扩展属性的存储方式
+const (
+	// XattrNone 不支持扩展属性，返回 ENOTSUP
+	XattrNone = "none"
+	// XattrSidecar 将扩展属性保存在远程的隐藏文件中：
+	// 文件 dir/name 对应 dir/.name.xattr，目录 dir 对应 dir/.xattr
+	XattrSidecar = "sidecar"
+)
+
+// xattrSuffix 扩展属性文件的后缀
+const xattrSuffix = ".xattr"
+
+// isXattrFile 判断 name 是否为扩展属性文件，这些文件不在目录中显示
+func isXattrFile(name string) bool {
+	return name == xattrSuffix || (strings.HasPrefix(name, ".") && strings.HasSuffix(name, xattrSuffix) && len(name) > len(xattrSuffix)+1)
+}
+
+// xattrs 扩展属性是否以 sidecar 方式保存
+func (n *Node) xattrs() bool {
+	return n.sftp.opts.Xattr == XattrSidecar
+}
+
+// reserved 判断 name 是否为保留给扩展属性文件的名称，这些名称不能通过挂载点查找或创建
+func (n *Node) reserved(name string) bool {
+	return n.xattrs() && isXattrFile(name)
+}
+
+// xattrPath 返回节点的扩展属性文件路径
+func (n *Node) xattrPath() string {
+	if n.isdir {
+		return path.Join(n.Path(), xattrSuffix)
+	}
+	return sidecarPath(n.Path())
+}
+
+// sidecarPath 返回文件 p 的扩展属性文件路径
+func sidecarPath(p string) string {
+	return path.Join(path.Dir(p), "."+path.Base(p)+xattrSuffix)
+}
+
+// moveXattrs 文件从 opath 重命名为 npath 后移动其扩展属性文件。
+// 源文件没有扩展属性文件时删除被覆盖目标的扩展属性文件，避免新文件继承目标的属性
+func (d *Dir) moveXattrs(ctx context.Context, opath, npath string) error {
+	err := d.sftp.Rename(ctx, sidecarPath(opath), sidecarPath(npath))
+	if err != os.ErrNotExist {
+		return err
+	}
+	return removeXattrFile(ctx, d.sftp, sidecarPath(npath))
+}
+
+// removeXattrFile 删除扩展属性文件，文件不存在时不报错
+func removeXattrFile(ctx context.Context, c *Client, p string) error {
+	err := c.Remove(ctx, p)
+	if err == os.ErrNotExist {
+		return nil
+	}
+	return err
+}
+
+// readXattrs 读取节点的全部扩展属性，文件不存在时返回空
+func (n *Node) readXattrs(ctx context.Context) (map[string][]byte, error) {
+	attrs := map[string][]byte{}
+	data, err := n.sftp.ReadFile(ctx, n.xattrPath())
+	if err == os.ErrNotExist {
+		return attrs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &attrs); err != nil {
+		return nil, err
+	}
+	return attrs, nil
+}
+
+// writeXattrs 保存节点的全部扩展属性，没有属性时删除扩展属性文件
+func (n *Node) writeXattrs(ctx context.Context, attrs map[string][]byte) error {
+	if len(attrs) == 0 {
+		return removeXattrFile(ctx, n.sftp, n.xattrPath())
+	}
+
+	data, err := json.Marshal(attrs)
+	if err != nil {
+		return err
+	}
+	return n.sftp.WriteFile(ctx, n.xattrPath(), data)
+}
+
+// getxattr 实现 Getxattr
+func (n *Node) getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	if !n.xattrs() {
+		return fuse.ENOTSUP
+	}
+	n.xattrLock.Lock()
+	defer n.xattrLock.Unlock()
+
+	attrs, err := n.readXattrs(ctx)
+	if err != nil {
+		return err
+	}
+	value, ok := attrs[req.Name]
+	if !ok {
+		return fuse.ErrNoXattr
+	}
+	resp.Xattr = value
+	return nil
+}
+
+// listxattr 实现 Listxattr
+func (n *Node) listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) error {
+	if !n.xattrs() {
+		return fuse.ENOTSUP
+	}
+	n.xattrLock.Lock()
+	defer n.xattrLock.Unlock()
+
+	attrs, err := n.readXattrs(ctx)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	resp.Append(names...)
+	return nil
+}
+
+// setxattr 实现 Setxattr，支持 XATTR_CREATE 和 XATTR_REPLACE
+func (n *Node) setxattr(ctx context.Context, req *fuse.SetxattrRequest) error {
+	if !n.xattrs() {
+		return fuse.ENOTSUP
+	}
+	n.xattrLock.Lock()
+	defer n.xattrLock.Unlock()
+
+	attrs, err := n.readXattrs(ctx)
+	if err != nil {
+		return err
+	}
+	_, ok := attrs[req.Name]
+	if ok && req.Flags&unix.XATTR_CREATE != 0 {
+		return fuse.Errno(unix.EEXIST)
+	}
+	if !ok && req.Flags&unix.XATTR_REPLACE != 0 {
+		return fuse.ErrNoXattr
+	}
+
+	// req.Xattr 在请求结束后会被复用，需要复制
+	attrs[req.Name] = append([]byte{}, req.Xattr...)
+	return n.writeXattrs(ctx, attrs)
+}
+
+// removexattr 实现 Removexattr
+func (n *Node) removexattr(ctx context.Context, req *fuse.RemovexattrRequest) error {
+	if !n.xattrs() {
+		return fuse.ENOTSUP
+	}
+	n.xattrLock.Lock()
+	defer n.xattrLock.Unlock()
+
+	attrs, err := n.readXattrs(ctx)
+	if err != nil {
+		return err
+	}
+	if _, ok := attrs[req.Name]; !ok {
+		return fuse.ErrNoXattr
+	}
+	delete(attrs, req.Name)
+	return n.writeXattrs(ctx, attrs)
+}